@@ -64,11 +64,12 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 		go d.routedDispatch(ctx, outbound, destination)
 	} else {
 		go func() {
-			domain, err := snifer(ctx, sniferList, outbound)
+			result, err := snifer(ctx, sniferList, outbound)
 			if err == nil {
-				newError("sniffed domain: ", domain).WriteToLog()
-				destination.Address = net.ParseAddress(domain)
+				newError("sniffed domain: ", result.Domain()).WriteToLog()
+				destination.Address = net.ParseAddress(result.Domain())
 				ctx = proxy.ContextWithTarget(ctx, destination)
+				ctx = proxy.ContextWithSniffingResult(ctx, result)
 			}
 			d.routedDispatch(ctx, outbound, destination)
 		}()
@@ -76,7 +77,7 @@ func (d *DefaultDispatcher) Dispatch(ctx context.Context, destination net.Destin
 	return outbound, nil
 }
 
-func snifer(ctx context.Context, sniferList []proxyman.KnownProtocols, outbound ray.OutboundRay) (string, error) {
+func snifer(ctx context.Context, sniferList []proxyman.KnownProtocols, outbound ray.OutboundRay) (proxy.SniffResult, error) {
 	payload := buf.New()
 	defer payload.Release()
 
@@ -85,21 +86,21 @@ func snifer(ctx context.Context, sniferList []proxyman.KnownProtocols, outbound
 	for {
 		select {
 		case <-ctx.Done():
-			return "", ctx.Err()
+			return nil, ctx.Err()
 		default:
 			totalAttempt++
 			if totalAttempt > 5 {
-				return "", errSniffingTimeout
+				return nil, errSniffingTimeout
 			}
 			outbound.OutboundInput().Peek(payload)
 			if !payload.IsEmpty() {
-				domain, err := sniffer.Sniff(payload.Bytes())
+				result, err := sniffer.Sniff(payload.Bytes())
 				if err != ErrMoreData {
-					return domain, err
+					return result, err
 				}
 			}
 			if payload.IsFull() {
-				return "", ErrInvalidData
+				return nil, ErrInvalidData
 			}
 			time.Sleep(time.Millisecond * 100)
 		}
@@ -109,6 +110,9 @@ func snifer(ctx context.Context, sniferList []proxyman.KnownProtocols, outbound
 func (d *DefaultDispatcher) routedDispatch(ctx context.Context, outbound ray.OutboundRay, destination net.Destination) {
 	dispatcher := d.ohm.GetDefaultHandler()
 	if d.router != nil {
+		route := &proxy.Route{RuleIndex: -1}
+		ctx = proxy.ContextWithRoute(ctx, route)
+		defer route.Close()
 		if tag, err := d.router.PickRoute(ctx); err == nil {
 			if handler := d.ohm.GetHandler(tag); handler != nil {
 				newError("taking detour [", tag, "] for [", destination, "]").WriteToLog()
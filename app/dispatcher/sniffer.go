@@ -2,10 +2,14 @@ package dispatcher
 
 import (
 	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
 	"strings"
 
 	"v2ray.com/core/app/proxyman"
 	"v2ray.com/core/common/serial"
+	"v2ray.com/core/proxy"
 )
 
 var (
@@ -25,151 +29,370 @@ func ContainsValidHTTPMethod(b []byte) bool {
 		part0Trimed == "delete" || part0Trimed == "options" || part0Trimed == "connect"
 }
 
+// HTTPHeader is the result of sniffing a HTTP request.
+type HTTPHeader struct {
+	domain  string
+	method  string
+	headers map[string]string
+}
+
+// Protocol implements proxy.SniffResult.
+func (*HTTPHeader) Protocol() string {
+	return "http"
+}
+
+// Domain implements proxy.SniffResult. It returns the host in the Host header.
+func (h *HTTPHeader) Domain() string {
+	return h.domain
+}
+
+// Method returns the request method in upper case, such as "GET".
+func (h *HTTPHeader) Method() string {
+	return h.method
+}
+
+// Header returns the value of the request header with the given name, which is case-insensitive.
+// Values of repeated headers are joined by ", ". Only headers within the sniffed data are available.
+func (h *HTTPHeader) Header(name string) (string, bool) {
+	value, found := h.headers[strings.ToLower(name)]
+	return value, found
+}
+
 func SniffHTTP(b []byte) (string, error) {
+	h, err := SniffHTTPHeader(b)
+	if err != nil {
+		return "", err
+	}
+	return h.Domain(), nil
+}
+
+// SniffHTTPHeader is the same as SniffHTTP, but returns the method and headers of the request as well.
+func SniffHTTPHeader(b []byte) (*HTTPHeader, error) {
 	if len(b) == 0 {
-		return "", ErrMoreData
+		return nil, ErrMoreData
+	}
+	lines := bytes.Split(b, []byte{'\n'})
+	if !ContainsValidHTTPMethod(lines[0]) {
+		return nil, ErrInvalidData
 	}
-	headers := bytes.Split(b, []byte{'\n'})
-	if !ContainsValidHTTPMethod(headers[0]) {
-		return "", ErrInvalidData
+	header := &HTTPHeader{
+		method:  strings.ToUpper(string(bytes.Split(lines[0], []byte{' '})[0])),
+		headers: make(map[string]string),
 	}
-	for i := 1; i < len(headers); i++ {
-		header := headers[i]
-		if len(header) == 0 {
-			return "", ErrInvalidData
+	hasHost := false
+	for i := 1; i < len(lines); i++ {
+		isLast := i == len(lines)-1
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			// End of headers, or end of the sniffed data.
+			if hasHost {
+				break
+			}
+			if isLast {
+				return nil, ErrMoreData
+			}
+			return nil, ErrInvalidData
 		}
-		parts := bytes.SplitN(header, []byte{':'}, 2)
+		parts := bytes.SplitN(line, []byte{':'}, 2)
 		if len(parts) != 2 {
-			return "", ErrInvalidData
+			if hasHost {
+				// Once the host is known, malformed lines, such as an incomplete line
+				// at the end of the sniffed data, are skipped.
+				continue
+			}
+			return nil, ErrInvalidData
+		}
+		key := strings.ToLower(string(bytes.TrimSpace(parts[0])))
+		value := string(bytes.TrimSpace(parts[1]))
+		if v, found := header.headers[key]; found {
+			value = v + ", " + value
 		}
-		key := strings.ToLower(string(parts[0]))
-		value := strings.ToLower(string(bytes.Trim(parts[1], " ")))
-		if key == "host" {
-			domain := strings.Split(value, ":")
-			return strings.TrimSpace(domain[0]), nil
+		header.headers[key] = value
+		if key == "host" && !hasHost {
+			hasHost = true
+			domain := strings.Split(strings.ToLower(value), ":")
+			header.domain = strings.TrimSpace(domain[0])
 		}
 	}
-	return "", ErrMoreData
+	if !hasHost {
+		return nil, ErrMoreData
+	}
+	return header, nil
 }
 
 func IsValidTLSVersion(major, minor byte) bool {
 	return major == 3
 }
 
+// TLSHeader is the result of sniffing a TLS ClientHello.
+type TLSHeader struct {
+	domain  string
+	ja3     string
+	version uint16
+}
+
+// Protocol implements proxy.SniffResult.
+func (*TLSHeader) Protocol() string {
+	return "tls"
+}
+
+// Domain implements proxy.SniffResult. It returns the server name in the ClientHello.
+func (h *TLSHeader) Domain() string {
+	return h.domain
+}
+
+// JA3 returns the JA3 fingerprint of the ClientHello, as a hex encoded MD5 hash.
+// It is empty if the extensions of the ClientHello could not be parsed.
+func (h *TLSHeader) JA3() string {
+	return h.ja3
+}
+
+// Version returns the highest TLS version offered in the ClientHello, such as 0x0303 for TLS 1.2.
+// It is 0 if the extensions of the ClientHello could not be parsed.
+func (h *TLSHeader) Version() uint16 {
+	return h.version
+}
+
+// isGREASE returns true if v is one of the GREASE values in RFC 8701, which are ignored by JA3.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func writeJA3List(b *bytes.Buffer, values []uint16) {
+	first := true
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+		if !first {
+			b.WriteByte('-')
+		}
+		first = false
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+}
+
 // ReadClientHello returns server name (if any) from TLS client hello message.
-// https://github.com/golang/go/blob/master/src/crypto/tls/handshake_messages.go#L300
 func ReadClientHello(data []byte) (string, error) {
+	h := new(TLSHeader)
+	if err := readClientHello(data, h); err != nil {
+		return "", err
+	}
+	return h.Domain(), nil
+}
+
+// readClientHello reads server name (if any) and JA3 fingerprint from TLS client hello message.
+// Extensions other than server name are parsed on a best-effort basis. If any of them is malformed,
+// the server name is still returned, without JA3 fingerprint and version.
+// https://github.com/golang/go/blob/master/src/crypto/tls/handshake_messages.go#L300
+func readClientHello(data []byte, h *TLSHeader) error {
 	if len(data) < 42 {
-		return "", ErrMoreData
+		return ErrMoreData
 	}
+	version := uint16(data[4])<<8 | uint16(data[5])
 	sessionIDLen := int(data[38])
 	if sessionIDLen > 32 || len(data) < 39+sessionIDLen {
-		return "", ErrInvalidData
+		return ErrInvalidData
 	}
 	data = data[39+sessionIDLen:]
 	if len(data) < 2 {
-		return "", ErrMoreData
+		return ErrMoreData
 	}
 	// cipherSuiteLen is the number of bytes of cipher suite numbers. Since
 	// they are uint16s, the number must be even.
 	cipherSuiteLen := int(data[0])<<8 | int(data[1])
 	if cipherSuiteLen%2 == 1 || len(data) < 2+cipherSuiteLen {
-		return "", ErrInvalidData
+		return ErrInvalidData
+	}
+	cipherSuites := make([]uint16, 0, cipherSuiteLen/2)
+	for i := 2; i < 2+cipherSuiteLen; i += 2 {
+		cipherSuites = append(cipherSuites, uint16(data[i])<<8|uint16(data[i+1]))
 	}
 	data = data[2+cipherSuiteLen:]
 	if len(data) < 1 {
-		return "", ErrMoreData
+		return ErrMoreData
 	}
 	compressionMethodsLen := int(data[0])
 	if len(data) < 1+compressionMethodsLen {
-		return "", ErrMoreData
+		return ErrMoreData
 	}
 	data = data[1+compressionMethodsLen:]
 
 	if len(data) == 0 {
-		return "", ErrInvalidData
+		return ErrInvalidData
 	}
 	if len(data) < 2 {
-		return "", ErrInvalidData
+		return ErrInvalidData
 	}
 
 	extensionsLength := int(data[0])<<8 | int(data[1])
 	data = data[2:]
 	if extensionsLength != len(data) {
-		return "", ErrInvalidData
+		return ErrInvalidData
 	}
 
+	var serverName string
+	var extensions, curves, pointFormats []uint16
+	maxVersion := version
+	fingerprint := true
 	for len(data) != 0 {
-		if len(data) < 4 {
-			return "", ErrInvalidData
+		if len(data) < 4 || len(data) < 4+(int(data[2])<<8|int(data[3])) {
+			// A malformed extension after the server name only invalidates the fingerprint.
+			if len(serverName) == 0 {
+				return ErrInvalidData
+			}
+			fingerprint = false
+			break
 		}
 		extension := uint16(data[0])<<8 | uint16(data[1])
 		length := int(data[2])<<8 | int(data[3])
 		data = data[4:]
-		if len(data) < length {
-			return "", ErrInvalidData
-		}
+		extensions = append(extensions, extension)
 
 		switch extension {
 		case 0x00: /* extensionServerName */
 			d := data[:length]
 			if len(d) < 2 {
-				return "", ErrInvalidData
+				return ErrInvalidData
 			}
 			namesLen := int(d[0])<<8 | int(d[1])
 			d = d[2:]
 			if len(d) != namesLen {
-				return "", ErrInvalidData
+				return ErrInvalidData
 			}
-			for len(d) > 0 {
+			for len(d) > 0 && len(serverName) == 0 {
 				if len(d) < 3 {
-					return "", ErrInvalidData
+					return ErrInvalidData
 				}
 				nameType := d[0]
 				nameLen := int(d[1])<<8 | int(d[2])
 				d = d[3:]
 				if len(d) < nameLen {
-					return "", ErrInvalidData
+					return ErrInvalidData
 				}
 				if nameType == 0 {
-					serverName := string(d[:nameLen])
+					serverName = string(d[:nameLen])
 					// An SNI value may not include a
 					// trailing dot. See
 					// https://tools.ietf.org/html/rfc6066#section-3.
 					if strings.HasSuffix(serverName, ".") {
-						return "", ErrInvalidData
+						return ErrInvalidData
 					}
-					return serverName, nil
 				}
 				d = d[nameLen:]
 			}
+		case 0x0a: /* extensionSupportedCurves */
+			d := data[:length]
+			if len(d) < 2 || int(d[0])<<8|int(d[1]) != len(d)-2 || len(d)%2 == 1 {
+				fingerprint = false
+				break
+			}
+			for i := 2; i < len(d); i += 2 {
+				curves = append(curves, uint16(d[i])<<8|uint16(d[i+1]))
+			}
+		case 0x0b: /* extensionSupportedPoints */
+			d := data[:length]
+			if len(d) < 1 || int(d[0]) != len(d)-1 {
+				fingerprint = false
+				break
+			}
+			for _, p := range d[1:] {
+				pointFormats = append(pointFormats, uint16(p))
+			}
+		case 0x2b: /* extensionSupportedVersions */
+			d := data[:length]
+			if len(d) < 1 || int(d[0]) != len(d)-1 || len(d)%2 == 0 {
+				fingerprint = false
+				break
+			}
+			for i := 1; i < len(d); i += 2 {
+				v := uint16(d[i])<<8 | uint16(d[i+1])
+				if !isGREASE(v) && v > maxVersion {
+					maxVersion = v
+				}
+			}
 		}
 		data = data[length:]
 	}
 
-	return "", ErrInvalidData
+	if len(serverName) == 0 {
+		return ErrInvalidData
+	}
+
+	h.domain = serverName
+	if !fingerprint {
+		return nil
+	}
+
+	var ja3 bytes.Buffer
+	ja3.WriteString(strconv.Itoa(int(version)))
+	ja3.WriteByte(',')
+	writeJA3List(&ja3, cipherSuites)
+	ja3.WriteByte(',')
+	writeJA3List(&ja3, extensions)
+	ja3.WriteByte(',')
+	writeJA3List(&ja3, curves)
+	ja3.WriteByte(',')
+	writeJA3List(&ja3, pointFormats)
+	hash := md5.Sum(ja3.Bytes())
+
+	h.ja3 = hex.EncodeToString(hash[:])
+	h.version = maxVersion
+	return nil
 }
 
 func SniffTLS(b []byte) (string, error) {
+	h, err := SniffTLSHeader(b)
+	if err != nil {
+		return "", err
+	}
+	return h.Domain(), nil
+}
+
+// SniffTLSHeader is the same as SniffTLS, but returns the JA3 fingerprint and version of the ClientHello as well.
+func SniffTLSHeader(b []byte) (*TLSHeader, error) {
 	if len(b) < 5 {
-		return "", ErrMoreData
+		return nil, ErrMoreData
 	}
 
 	if b[0] != 0x16 /* TLS Handshake */ {
-		return "", ErrInvalidData
+		return nil, ErrInvalidData
 	}
 	if !IsValidTLSVersion(b[1], b[2]) {
-		return "", ErrInvalidData
+		return nil, ErrInvalidData
 	}
 	headerLen := int(serial.BytesToUint16(b[3:5]))
 	if 5+headerLen > len(b) {
-		return "", ErrMoreData
+		return nil, ErrMoreData
+	}
+
+	h := new(TLSHeader)
+	if err := readClientHello(b[5:5+headerLen], h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+type protocolSniffer func([]byte) (proxy.SniffResult, error)
+
+func sniffHTTPResult(b []byte) (proxy.SniffResult, error) {
+	h, err := SniffHTTPHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func sniffTLSResult(b []byte) (proxy.SniffResult, error) {
+	h, err := SniffTLSHeader(b)
+	if err != nil {
+		return nil, err
 	}
-	return ReadClientHello(b[5 : 5+headerLen])
+	return h, nil
 }
 
 type Sniffer struct {
-	slist []func([]byte) (string, error)
+	slist []protocolSniffer
 	err   []error
 }
 
@@ -177,39 +400,39 @@ func NewSniffer(sniferList []proxyman.KnownProtocols) *Sniffer {
 	s := new(Sniffer)
 
 	for _, protocol := range sniferList {
-		var f func([]byte) (string, error)
+		var ps protocolSniffer
 		switch protocol {
 		case proxyman.KnownProtocols_HTTP:
-			f = SniffHTTP
+			ps = sniffHTTPResult
 		case proxyman.KnownProtocols_TLS:
-			f = SniffTLS
+			ps = sniffTLSResult
 		default:
 			panic("Unsupported protocol")
 		}
-		s.slist = append(s.slist, f)
+		s.slist = append(s.slist, ps)
 	}
 	s.err = make([]error, len(s.slist))
 
 	return s
 }
 
-func (s *Sniffer) Sniff(payload []byte) (string, error) {
+func (s *Sniffer) Sniff(payload []byte) (proxy.SniffResult, error) {
 	sniffed := false
 	for idx, sniffer := range s.slist {
 		if s.err[idx] != nil {
 			continue
 		}
 		sniffed = true
-		domain, err := sniffer(payload)
+		result, err := sniffer(payload)
 		if err == nil {
-			return domain, nil
+			return result, nil
 		}
 		if err != ErrMoreData {
 			s.err[idx] = err
 		}
 	}
 	if sniffed {
-		return "", ErrMoreData
+		return nil, ErrMoreData
 	}
-	return "", s.err[0]
+	return nil, s.err[0]
 }
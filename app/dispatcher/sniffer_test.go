@@ -1,6 +1,7 @@
 package dispatcher_test
 
 import (
+	"bytes"
 	"testing"
 
 	. "v2ray.com/core/app/dispatcher"
@@ -186,8 +187,127 @@ func TestTLSHeaders(t *testing.T) {
 	}
 }
 
+// clientHello is a ClientHello from Chrome, with server name c.s-microsoft.com.
+var clientHello = []byte{
+	0x16, 0x03, 0x01, 0x00, 0xc8, 0x01, 0x00, 0x00,
+	0xc4, 0x03, 0x03, 0x1a, 0xac, 0xb2, 0xa8, 0xfe,
+	0xb4, 0x96, 0x04, 0x5b, 0xca, 0xf7, 0xc1, 0xf4,
+	0x2e, 0x53, 0x24, 0x6e, 0x34, 0x0c, 0x58, 0x36,
+	0x71, 0x97, 0x59, 0xe9, 0x41, 0x66, 0xe2, 0x43,
+	0xa0, 0x13, 0xb6, 0x00, 0x00, 0x20, 0x1a, 0x1a,
+	0xc0, 0x2b, 0xc0, 0x2f, 0xc0, 0x2c, 0xc0, 0x30,
+	0xcc, 0xa9, 0xcc, 0xa8, 0xcc, 0x14, 0xcc, 0x13,
+	0xc0, 0x13, 0xc0, 0x14, 0x00, 0x9c, 0x00, 0x9d,
+	0x00, 0x2f, 0x00, 0x35, 0x00, 0x0a, 0x01, 0x00,
+	0x00, 0x7b, 0xba, 0xba, 0x00, 0x00, 0xff, 0x01,
+	0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x16, 0x00,
+	0x14, 0x00, 0x00, 0x11, 0x63, 0x2e, 0x73, 0x2d,
+	0x6d, 0x69, 0x63, 0x72, 0x6f, 0x73, 0x6f, 0x66,
+	0x74, 0x2e, 0x63, 0x6f, 0x6d, 0x00, 0x17, 0x00,
+	0x00, 0x00, 0x23, 0x00, 0x00, 0x00, 0x0d, 0x00,
+	0x14, 0x00, 0x12, 0x04, 0x03, 0x08, 0x04, 0x04,
+	0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08,
+	0x06, 0x06, 0x01, 0x02, 0x01, 0x00, 0x05, 0x00,
+	0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x12,
+	0x00, 0x00, 0x00, 0x10, 0x00, 0x0e, 0x00, 0x0c,
+	0x02, 0x68, 0x32, 0x08, 0x68, 0x74, 0x74, 0x70,
+	0x2f, 0x31, 0x2e, 0x31, 0x00, 0x0b, 0x00, 0x02,
+	0x01, 0x00, 0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08,
+	0xaa, 0xaa, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18,
+	0xaa, 0xaa, 0x00, 0x01, 0x00,
+}
+
+func TestTLSHeaderFingerprint(t *testing.T) {
+	assert := With(t)
+
+	header, err := SniffTLSHeader(clientHello)
+	assert(err, IsNil)
+	assert(header.Domain(), Equals, "c.s-microsoft.com")
+	assert(header.JA3(), Equals, "b8f81673c0e1d29908346f3bab892b9b")
+}
+
+func TestSnifferProtocol(t *testing.T) {
+	assert := With(t)
+
+	sniffer := NewSniffer([]proxyman.KnownProtocols{proxyman.KnownProtocols_TLS, proxyman.KnownProtocols_HTTP})
+	result, err := sniffer.Sniff([]byte("GET / HTTP/1.1\r\nHost: v2ray.com\r\n\r\n"))
+	assert(err, IsNil)
+	assert(result.Protocol(), Equals, "http")
+	assert(result.Domain(), Equals, "v2ray.com")
+}
+
 func TestUnknownSniffer(t *testing.T) {
 	assert := With(t)
 
 	assert(func() { NewSniffer([]proxyman.KnownProtocols{proxyman.KnownProtocols(-1)}) }, Panics)
 }
+
+func TestHTTPHeaderValues(t *testing.T) {
+	assert := With(t)
+
+	header, err := SniffHTTPHeader([]byte("GET / HTTP/1.1\r\nHost: V2Ray.com:8080\r\nX-Requested-With: XMLHttpRequest\r\nAccept: text/html\r\naccept: */*\r\n\r\nX-After-Body: 1\r\n"))
+	assert(err, IsNil)
+	assert(header.Domain(), Equals, "v2ray.com")
+	assert(header.Method(), Equals, "GET")
+
+	value, found := header.Header("x-requested-with")
+	assert(found, IsTrue)
+	assert(value, Equals, "XMLHttpRequest")
+
+	value, found = header.Header("ACCEPT")
+	assert(found, IsTrue)
+	assert(value, Equals, "text/html, */*")
+
+	_, found = header.Header("Authorization")
+	assert(found, IsFalse)
+
+	_, found = header.Header("X-After-Body")
+	assert(found, IsFalse)
+
+	// Incomplete line at the end of data.
+	header, err = SniffHTTPHeader([]byte("GET / HTTP/1.1\r\nHost: v2ray.com\r\nAuthorization: Basic\r\nUser-Age"))
+	assert(err, IsNil)
+	_, found = header.Header("Authorization")
+	assert(found, IsTrue)
+
+	_, err = SniffHTTPHeader([]byte("GET / HTTP/1.1\r\nUser-Agent: curl\r\n"))
+	assert(err, Equals, ErrMoreData)
+
+	// Malformed line after the host.
+	header, err = SniffHTTPHeader([]byte("GET / HTTP/1.1\r\nHost: v2ray.com\r\nX-Broken\r\nAccept: */*\r\n\r\n"))
+	assert(err, IsNil)
+	assert(header.Domain(), Equals, "v2ray.com")
+	value, found = header.Header("Accept")
+	assert(found, IsTrue)
+	assert(value, Equals, "*/*")
+	_, found = header.Header("X-Broken")
+	assert(found, IsFalse)
+
+	_, err = SniffHTTPHeader([]byte("GET / HTTP/1.1\r\nX-Broken\r\nHost: v2ray.com\r\n\r\n"))
+	assert(err, Equals, ErrInvalidData)
+}
+
+func TestTLSHeadersMalformedExtension(t *testing.T) {
+	assert := With(t)
+
+	corrupt := func(ext []byte, replace []byte) []byte {
+		input := append([]byte(nil), clientHello...)
+		idx := bytes.Index(input, ext)
+		assert(idx, GreaterThan, 0)
+		copy(input[idx:], replace)
+		return input
+	}
+
+	for _, input := range [][]byte{
+		// Supported curves with a wrong list length.
+		corrupt([]byte{0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08}, []byte{0x00, 0x0a, 0x00, 0x0a, 0x00, 0x07}),
+		// Point formats with a wrong list length.
+		corrupt([]byte{0x00, 0x0b, 0x00, 0x02, 0x01}, []byte{0x00, 0x0b, 0x00, 0x02, 0x05}),
+	} {
+		header, err := SniffTLSHeader(input)
+		assert(err, IsNil)
+		assert(header.Domain(), Equals, "c.s-microsoft.com")
+		assert(header.JA3(), Equals, "")
+		assert(header.Version(), Equals, uint16(0))
+	}
+}
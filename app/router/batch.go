@@ -0,0 +1,67 @@
+package router
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"v2ray.com/core/common/net"
+	"v2ray.com/core/proxy"
+)
+
+// RoutingContext describes a connection to be routed by BatchPick.
+type RoutingContext struct {
+	Source     net.Destination
+	Target     net.Destination
+	InboundTag string
+	// SniffingDisabled simulates an inbound without sniffing. By default, the inbound sniffs.
+	SniffingDisabled bool
+}
+
+// BatchPick routes the given connections in parallel, and returns decisions in the same order.
+// Routing doesn't change the state of the router: metrics are not counted, and stateful conditions,
+// such as rate limits, report their current state without updating it. Direct probes are not made,
+// so only cached probe results can match.
+func (r *Router) BatchPick(inputs []RoutingContext) []RouteDecision {
+	decisions := make([]RouteDecision, len(inputs))
+
+	workers := runtime.NumCPU()
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				idx := int(atomic.AddInt64(&next, 1))
+				if idx >= len(inputs) {
+					return
+				}
+				decisions[idx] = r.pickDecision(&inputs[idx])
+			}
+		}()
+	}
+	wg.Wait()
+	return decisions
+}
+
+func (r *Router) pickDecision(input *RoutingContext) RouteDecision {
+	ctx := contextWithDryRun(context.Background())
+	if !input.SniffingDisabled {
+		ctx = proxy.ContextWithSniffingEnabled(ctx)
+	}
+	if input.Source.IsValid() {
+		ctx = proxy.ContextWithSource(ctx, input.Source)
+	}
+	if input.Target.IsValid() {
+		ctx = proxy.ContextWithTarget(ctx, input.Target)
+	}
+	if len(input.InboundTag) > 0 {
+		ctx = proxy.ContextWithInboundTag(ctx, input.InboundTag)
+	}
+	return *r.pick(ctx)
+}
@@ -0,0 +1,137 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"v2ray.com/core/common/net"
+	"v2ray.com/core/proxy"
+	. "v2ray.com/ext/assert"
+)
+
+func TestBatchPick(t *testing.T) {
+	assert := With(t)
+
+	r, err := newRouter(&Config{
+		DomainStrategy: Config_IpIfNonMatch,
+		Rule: []*RoutingRule{
+			{
+				Tag:    "domain",
+				Domain: []*Domain{{Type: Domain_Domain, Value: "v2ray.com"}},
+			},
+			{
+				Tag: "lan",
+				Cidr: []*CIDR{
+					{
+						Ip:     []byte{192, 168, 0, 0},
+						Prefix: 16,
+					},
+				},
+			},
+			{
+				Tag:       "dns",
+				PortRange: net.SinglePortRange(53),
+			},
+			{
+				Tag:        "in",
+				InboundTag: []string{"in"},
+			},
+		},
+	}, mapDNSClient{
+		"nas.lan": {net.IP{192, 168, 1, 2}},
+	})
+	assert(err, IsNil)
+
+	inputs := []RoutingContext{
+		{Target: net.TCPDestination(net.DomainAddress("www.v2ray.com"), 443)},
+		{Target: net.TCPDestination(net.ParseAddress("192.168.1.1"), 80)},
+		{Target: net.TCPDestination(net.DomainAddress("nas.lan"), 80)},
+		{Target: net.UDPDestination(net.ParseAddress("8.8.8.8"), 53)},
+		{Target: net.TCPDestination(net.ParseAddress("8.8.8.8"), 80), InboundTag: "in"},
+		{Target: net.TCPDestination(net.ParseAddress("8.8.8.8"), 80)},
+	}
+	expected := []RouteDecision{
+		{OutboundTag: "domain", RuleIndex: 0},
+		{OutboundTag: "lan", RuleIndex: 1},
+		{OutboundTag: "lan", RuleIndex: 1},
+		{OutboundTag: "dns", RuleIndex: 2},
+		{OutboundTag: "in", RuleIndex: 3},
+		{RuleIndex: -1},
+	}
+
+	// Repeat the inputs, so that they are spread over workers.
+	var batch []RoutingContext
+	for i := 0; i < 50; i++ {
+		batch = append(batch, inputs...)
+	}
+	decisions := r.BatchPick(batch)
+	assert(len(decisions), Equals, len(batch))
+	for i, d := range decisions {
+		e := expected[i%len(expected)]
+		assert(d.OutboundTag, Equals, e.OutboundTag)
+		assert(d.RuleIndex, Equals, e.RuleIndex)
+		assert(d.Err == nil, Equals, len(e.OutboundTag) > 0)
+	}
+
+	assert(len(r.BatchPick(nil)), Equals, 0)
+}
+
+func TestBatchPickWithoutSideEffects(t *testing.T) {
+	assert := With(t)
+
+	r, err := newRouter(&Config{
+		Rule: []*RoutingRule{
+			{
+				Tag:       "limited",
+				PortRange: net.SinglePortRange(443),
+				RateLimit: &RateLimit{Rate: 1},
+			},
+			{
+				Tag:       "new",
+				PortRange: net.SinglePortRange(80),
+				FirstSeen: &FirstSeen{},
+			},
+			{
+				Tag:    "domain",
+				Domain: []*Domain{{Type: Domain_Domain, Value: "v2ray.com"}},
+			},
+		},
+	}, mapDNSClient{})
+	assert(err, IsNil)
+
+	source := net.TCPDestination(net.ParseAddress("10.0.0.1"), 10000)
+	inputs := []RoutingContext{
+		{Source: source, Target: net.TCPDestination(net.ParseAddress("8.8.8.8"), 443)},
+		{Source: source, Target: net.TCPDestination(net.ParseAddress("8.8.8.8"), 80)},
+		{Source: source, Target: net.TCPDestination(net.ParseAddress("1.1.1.1"), 8080), SniffingDisabled: true},
+	}
+	var batch []RoutingContext
+	for i := 0; i < 20; i++ {
+		batch = append(batch, inputs...)
+	}
+	for i, d := range r.BatchPick(batch) {
+		switch i % len(inputs) {
+		case 0:
+			assert(d.OutboundTag, Equals, "limited")
+			assert(d.SniffingDisabled, IsFalse)
+		case 1:
+			assert(d.OutboundTag, Equals, "new")
+		case 2:
+			assert(d.RuleIndex, Equals, -1)
+			assert(d.SniffingDisabled, IsTrue)
+		}
+	}
+
+	assert(r.metrics.ruleMatches, Equals, []uint64{0, 0, 0})
+	assert(r.metrics.unmatched, Equals, uint64(0))
+	assert(r.sniffWarned, Equals, []uint32{0, 0, 0})
+
+	// The token and the first connection of the source are still there.
+	for _, input := range inputs[:2] {
+		ctx := proxy.ContextWithSource(context.Background(), input.Source)
+		ctx = proxy.ContextWithTarget(ctx, input.Target)
+		d := r.pick(ctx)
+		assert(d.RuleIndex, NotEquals, -1)
+	}
+	assert(r.metrics.ruleMatches, Equals, []uint64{1, 1, 0})
+}
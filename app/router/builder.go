@@ -0,0 +1,204 @@
+package router
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"v2ray.com/core/common/net"
+)
+
+// ConfigBuilder builds a routing Config step by step.
+type ConfigBuilder struct {
+	config *Config
+	err    error
+}
+
+// NewConfigBuilder returns a ConfigBuilder for an empty Config.
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{
+		config: new(Config),
+	}
+}
+
+// DomainStrategy sets the domain strategy of the config.
+func (b *ConfigBuilder) DomainStrategy(s Config_DomainStrategy) *ConfigBuilder {
+	b.config.DomainStrategy = s
+	return b
+}
+
+// DefaultOutbound sets the outbound tag to use when no rule matches.
+func (b *ConfigBuilder) DefaultOutbound(tag string) *ConfigBuilder {
+	b.config.DefaultOutboundTag = tag
+	return b
+}
+
+// AddRule appends a new rule to the config. Call Done() on the returned RuleBuilder to return to the ConfigBuilder.
+func (b *ConfigBuilder) AddRule() *RuleBuilder {
+	rule := new(RoutingRule)
+	b.config.Rule = append(b.config.Rule, rule)
+	return &RuleBuilder{
+		parent: b,
+		rule:   rule,
+	}
+}
+
+// Build validates the config and returns a copy of it. It returns the first error found in the config.
+func (b *ConfigBuilder) Build() (*Config, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if err := b.config.checkLimits(); err != nil {
+		return nil, err
+	}
+	for idx, rule := range b.config.Rule {
+		if len(rule.Tag) == 0 {
+			return nil, newError("rule ", idx, " has no outbound tag")
+		}
+		if _, err := rule.build(b.config); err != nil {
+			return nil, withRuleIndex(err, idx)
+		}
+	}
+	return proto.Clone(b.config).(*Config), nil
+}
+
+// RuleBuilder builds a single routing rule of a ConfigBuilder.
+type RuleBuilder struct {
+	parent *ConfigBuilder
+	rule   *RoutingRule
+}
+
+func (r *RuleBuilder) fail(err error) {
+	if r.parent.err == nil {
+		r.parent.err = err
+	}
+}
+
+// MatchDomain adds domains to match. A domain may have a prefix for its type:
+// "full:" for the exact domain, "domain:" for the domain and its subdomains,
+// "keyword:" for domains containing the value, and "regexp:" for a regular expression.
+// Domains without a prefix are matched as "domain:", and other prefixes are rejected.
+func (r *RuleBuilder) MatchDomain(domains ...string) *RuleBuilder {
+	for _, d := range domains {
+		prefix, value := "domain", d
+		if idx := strings.Index(d, ":"); idx >= 0 {
+			prefix, value = d[:idx], d[idx+1:]
+		}
+		if len(value) == 0 {
+			r.fail(newError("empty domain: ", d))
+			continue
+		}
+		domain := &Domain{
+			Value: value,
+		}
+		switch prefix {
+		case "full":
+			domain.Type = Domain_Regex
+			domain.Value = "^" + regexp.QuoteMeta(value) + "$"
+		case "domain":
+			domain.Type = Domain_Domain
+		case "keyword":
+			domain.Type = Domain_Plain
+		case "regexp":
+			domain.Type = Domain_Regex
+		default:
+			r.fail(newError("unknown domain type: ", d))
+			continue
+		}
+		r.rule.Domain = append(r.rule.Domain, domain)
+	}
+	return r
+}
+
+func parseCIDR(s string) (*CIDR, error) {
+	parts := strings.SplitN(s, "/", 2)
+	addr := net.ParseAddress(parts[0])
+	if addr.Family().IsDomain() {
+		return nil, newError("invalid IP in CIDR: ", s)
+	}
+	ip := addr.IP()
+	prefix := uint64(len(ip) * 8)
+	if len(parts) == 2 {
+		p, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil || p > prefix {
+			return nil, newError("invalid prefix in CIDR: ", s)
+		}
+		prefix = p
+	}
+	return &CIDR{
+		Ip:     ip,
+		Prefix: uint32(prefix),
+	}, nil
+}
+
+// MatchCIDR adds destination IP ranges to match, such as "10.0.0.0/8". A single IP matches itself only.
+func (r *RuleBuilder) MatchCIDR(cidrs ...string) *RuleBuilder {
+	for _, s := range cidrs {
+		cidr, err := parseCIDR(s)
+		if err != nil {
+			r.fail(err)
+			continue
+		}
+		r.rule.Cidr = append(r.rule.Cidr, cidr)
+	}
+	return r
+}
+
+// MatchSourceCIDR adds source IP ranges to match, in the same form as MatchCIDR.
+func (r *RuleBuilder) MatchSourceCIDR(cidrs ...string) *RuleBuilder {
+	for _, s := range cidrs {
+		cidr, err := parseCIDR(s)
+		if err != nil {
+			r.fail(err)
+			continue
+		}
+		r.rule.SourceCidr = append(r.rule.SourceCidr, cidr)
+	}
+	return r
+}
+
+// MatchPort sets the range of destination ports to match.
+func (r *RuleBuilder) MatchPort(from net.Port, to net.Port) *RuleBuilder {
+	if from > to {
+		r.fail(newError("invalid port range: ", from, "-", to))
+		return r
+	}
+	r.rule.PortRange = &net.PortRange{
+		From: uint32(from),
+		To:   uint32(to),
+	}
+	return r
+}
+
+// MatchNetwork adds networks to match.
+func (r *RuleBuilder) MatchNetwork(networks ...net.Network) *RuleBuilder {
+	if r.rule.NetworkList == nil {
+		r.rule.NetworkList = new(net.NetworkList)
+	}
+	r.rule.NetworkList.Network = append(r.rule.NetworkList.Network, networks...)
+	return r
+}
+
+// MatchInboundTag adds inbound tags to match.
+func (r *RuleBuilder) MatchInboundTag(tags ...string) *RuleBuilder {
+	r.rule.InboundTag = append(r.rule.InboundTag, tags...)
+	return r
+}
+
+// MatchUserEmail adds user emails to match.
+func (r *RuleBuilder) MatchUserEmail(emails ...string) *RuleBuilder {
+	r.rule.UserEmail = append(r.rule.UserEmail, emails...)
+	return r
+}
+
+// ToOutbound sets the outbound tag of the rule.
+func (r *RuleBuilder) ToOutbound(tag string) *RuleBuilder {
+	r.rule.Tag = tag
+	return r
+}
+
+// Done finishes the rule and returns the ConfigBuilder.
+func (r *RuleBuilder) Done() *ConfigBuilder {
+	return r.parent
+}
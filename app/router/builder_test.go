@@ -0,0 +1,95 @@
+package router_test
+
+import (
+	"testing"
+
+	proto "github.com/golang/protobuf/proto"
+	. "v2ray.com/core/app/router"
+	"v2ray.com/core/common/net"
+	. "v2ray.com/ext/assert"
+)
+
+func TestConfigBuilder(t *testing.T) {
+	assert := With(t)
+
+	config, err := NewConfigBuilder().
+		DomainStrategy(Config_IpIfNonMatch).
+		DefaultOutbound("proxy").
+		AddRule().MatchDomain("full:v2ray.com", "domain:google.com", "keyword:ads", "regexp:^cdn\\d+\\.", "example.com").ToOutbound("direct").Done().
+		AddRule().MatchCIDR("10.0.0.0/8", "2001:db8::/32", "8.8.8.8").MatchPort(53, 53).MatchNetwork(net.Network_UDP).ToOutbound("dns").Done().
+		AddRule().MatchInboundTag("socks").MatchSourceCIDR("192.168.0.0/16").MatchUserEmail("love@v2ray.com").ToOutbound("lan").Done().
+		Build()
+	assert(err, IsNil)
+
+	expected := &Config{
+		DomainStrategy:     Config_IpIfNonMatch,
+		DefaultOutboundTag: "proxy",
+		Rule: []*RoutingRule{
+			{
+				Tag: "direct",
+				Domain: []*Domain{
+					{Type: Domain_Regex, Value: "^v2ray\\.com$"},
+					{Type: Domain_Domain, Value: "google.com"},
+					{Type: Domain_Plain, Value: "ads"},
+					{Type: Domain_Regex, Value: "^cdn\\d+\\."},
+					{Type: Domain_Domain, Value: "example.com"},
+				},
+			},
+			{
+				Tag: "dns",
+				Cidr: []*CIDR{
+					{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+					{Ip: net.ParseIP("2001:db8::"), Prefix: 32},
+					{Ip: []byte{8, 8, 8, 8}, Prefix: 32},
+				},
+				PortRange:   net.SinglePortRange(53),
+				NetworkList: net.Network_UDP.AsList(),
+			},
+			{
+				Tag:        "lan",
+				InboundTag: []string{"socks"},
+				SourceCidr: []*CIDR{
+					{Ip: []byte{192, 168, 0, 0}, Prefix: 16},
+				},
+				UserEmail: []string{"love@v2ray.com"},
+			},
+		},
+	}
+	assert(proto.Equal(config, expected), IsTrue)
+}
+
+func TestConfigBuilderCopy(t *testing.T) {
+	assert := With(t)
+
+	b := NewConfigBuilder().AddRule().MatchDomain("v2ray.com").ToOutbound("direct").Done()
+	config, err := b.Build()
+	assert(err, IsNil)
+
+	b.DefaultOutbound("proxy").AddRule().MatchPort(53, 53).ToOutbound("dns").Done()
+	assert(len(config.Rule), Equals, 1)
+	assert(config.DefaultOutboundTag, Equals, "")
+
+	config2, err := b.Build()
+	assert(err, IsNil)
+	assert(len(config2.Rule), Equals, 2)
+	assert(config2.Rule[0] == config.Rule[0], IsFalse)
+}
+
+func TestConfigBuilderErrors(t *testing.T) {
+	assert := With(t)
+
+	builders := []*ConfigBuilder{
+		NewConfigBuilder().AddRule().MatchCIDR("10.0.0.0/33").ToOutbound("direct").Done(),
+		NewConfigBuilder().AddRule().MatchCIDR("v2ray.com").ToOutbound("direct").Done(),
+		NewConfigBuilder().AddRule().MatchDomain("full:").ToOutbound("direct").Done(),
+		NewConfigBuilder().AddRule().MatchDomain("geosite:cn").ToOutbound("direct").Done(),
+		NewConfigBuilder().AddRule().MatchDomain("regexp:(").ToOutbound("direct").Done(),
+		NewConfigBuilder().AddRule().MatchPort(443, 80).ToOutbound("direct").Done(),
+		NewConfigBuilder().AddRule().MatchDomain("v2ray.com").Done(),
+		NewConfigBuilder().AddRule().ToOutbound("direct").Done(),
+	}
+	for _, b := range builders {
+		_, err := b.Build()
+		assert(err, IsNotNil)
+	}
+}
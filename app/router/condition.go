@@ -1,8 +1,12 @@
 package router
 
 import (
+	"container/list"
 	"context"
+	"hash/fnv"
+	"math"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -10,12 +14,61 @@ import (
 	"v2ray.com/core/common/net"
 	"v2ray.com/core/common/protocol"
 	"v2ray.com/core/proxy"
+	"v2ray.com/core/transport/internet"
 )
 
+type key int
+
+const (
+	sourceConnectionsKey key = iota
+	outboundConnectionsKey
+	dryRunKey
+)
+
+func contextWithSourceConnections(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, sourceConnectionsKey, n)
+}
+
+func sourceConnectionsFromContext(ctx context.Context) (int, bool) {
+	n, ok := ctx.Value(sourceConnectionsKey).(int)
+	return n, ok
+}
+
+// contextWithDryRun marks the routing as simulated. Stateful conditions then report their result
+// without updating their state.
+func contextWithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey, true)
+}
+
+func isDryRun(ctx context.Context) bool {
+	return ctx.Value(dryRunKey) != nil
+}
+
+func contextWithOutboundConnections(ctx context.Context, c *connCounter) context.Context {
+	return context.WithValue(ctx, outboundConnectionsKey, c)
+}
+
+func outboundConnectionsFromContext(ctx context.Context) *connCounter {
+	c, _ := ctx.Value(outboundConnectionsKey).(*connCounter)
+	return c
+}
+
 type Condition interface {
 	Apply(ctx context.Context) bool
 }
 
+// statefulCondition is a Condition that changes state when it matches. Check tells whether it
+// matches without changing state, and Commit records the match.
+type statefulCondition interface {
+	Condition
+	Check(ctx context.Context) bool
+	// Commit returns false if the condition no longer matches, which may happen when
+	// connections are routed concurrently.
+	Commit(ctx context.Context) bool
+}
+
+// ConditionChan matches if all of its conditions match. Stateful conditions are only checked
+// while the chain is applied, and commit their state once all conditions matched.
 type ConditionChan []Condition
 
 func NewConditionChan() *ConditionChan {
@@ -29,11 +82,27 @@ func (v *ConditionChan) Add(cond Condition) *ConditionChan {
 }
 
 func (v *ConditionChan) Apply(ctx context.Context) bool {
+	var stateful []statefulCondition
 	for _, cond := range *v {
+		if s, ok := cond.(statefulCondition); ok {
+			if !s.Check(ctx) {
+				return false
+			}
+			stateful = append(stateful, s)
+			continue
+		}
 		if !cond.Apply(ctx) {
 			return false
 		}
 	}
+	if isDryRun(ctx) {
+		return true
+	}
+	for _, s := range stateful {
+		if !s.Commit(ctx) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -41,6 +110,67 @@ func (v *ConditionChan) Len() int {
 	return len(*v)
 }
 
+// Relative costs of evaluating conditions.
+const (
+	costCheap = iota
+	costDomain
+	costResolve
+	costProbe
+	// Conditions with side effects are evaluated last. Their state is only changed after all
+	// other conditions matched, see ConditionChan.
+	costSideEffect
+)
+
+func conditionCost(cond Condition) int {
+	switch c := cond.(type) {
+	case *CachableDomainMatcher, *HostnameEntropyMatcher:
+		return costDomain
+	case *CIDRMatcher:
+		if c.onSource {
+			return costCheap
+		}
+		return costResolve
+	case *IPv4Matcher:
+		if c.onSource {
+			return costCheap
+		}
+		return costResolve
+	case *ResolutionFailedMatcher, *SameSubnetMatcher:
+		return costResolve
+	case *DirectProbeMatcher:
+		return costProbe
+	case *FirstSeenMatcher, *RateLimitMatcher:
+		return costSideEffect
+	case *AnyCondition:
+		cost := costCheap
+		for _, sub := range *c {
+			if subCost := conditionCost(sub); subCost > cost {
+				cost = subCost
+			}
+		}
+		return cost
+	case *ConditionChan:
+		cost := costCheap
+		for _, sub := range *c {
+			if subCost := conditionCost(sub); subCost > cost {
+				cost = subCost
+			}
+		}
+		return cost
+	default:
+		return costCheap
+	}
+}
+
+// sortByCost reorders the conditions so that cheap ones are evaluated first.
+// As all conditions must match, this doesn't change the result.
+func (v *ConditionChan) sortByCost() {
+	conds := *v
+	sort.SliceStable(conds, func(i, j int) bool {
+		return conditionCost(conds[i]) < conditionCost(conds[j])
+	})
+}
+
 type AnyCondition []Condition
 
 func NewAnyCondition() *AnyCondition {
@@ -76,6 +206,9 @@ type CachableDomainMatcher struct {
 	matchers []domainMatcher
 	cache    map[string]timedResult
 	lastScan time.Time
+
+	// unanchoredRegex disables anchoring of regex domains for all domains added.
+	unanchoredRegex bool
 }
 
 func NewCachableDomainMatcher() *CachableDomainMatcher {
@@ -90,19 +223,39 @@ func (m *CachableDomainMatcher) Add(domain *Domain) error {
 	case Domain_Plain:
 		m.matchers = append(m.matchers, NewPlainDomainMatcher(domain.Value))
 	case Domain_Regex:
-		rm, err := NewRegexpDomainMatcher(domain.Value)
+		pattern := domain.Value
+		if !domain.Unanchored && !m.unanchoredRegex {
+			pattern = anchorRegex(pattern)
+		}
+		rm, err := NewRegexpDomainMatcher(pattern)
 		if err != nil {
-			return err
+			return &BadRegexError{Pattern: domain.Value, Err: err}
 		}
 		m.matchers = append(m.matchers, rm)
 	case Domain_Domain:
-		m.matchers = append(m.matchers, NewSubDomainMatcher(domain.Value))
+		var dm domainMatcher
+		if strings.HasPrefix(domain.Value, "*.") {
+			dm = NewWildcardDomainMatcher(domain.Value)
+		} else {
+			dm = NewSubDomainMatcher(domain.Value)
+		}
+		m.matchers = append(m.matchers, withMinLabels(dm, domain.MinLabels))
+	case Domain_Wildcard:
+		m.matchers = append(m.matchers, withMinLabels(NewWildcardDomainMatcher(domain.Value), domain.MinLabels))
 	default:
 		return newError("unknown domain type: ", domain.Type).AtWarning()
 	}
 	return nil
 }
 
+// anchorRegex makes the pattern match the whole domain, unless it is already anchored at either end.
+func anchorRegex(pattern string) string {
+	if strings.HasPrefix(pattern, "^") || strings.HasSuffix(pattern, "$") {
+		return pattern
+	}
+	return "^(?:" + pattern + ")$"
+}
+
 func (m *CachableDomainMatcher) applyInternal(domain string) bool {
 	for _, matcher := range m.matchers {
 		if matcher.Apply(domain) {
@@ -240,6 +393,39 @@ func (m SubDomainMatcher) Apply(domain string) bool {
 	return len(domain) == len(pattern) || domain[len(domain)-len(pattern)-1] == '.'
 }
 
+// withMinLabels restricts the matcher to domains with at least min labels, if min is set.
+func withMinLabels(matcher domainMatcher, min uint32) domainMatcher {
+	if min == 0 {
+		return matcher
+	}
+	return &minLabelsMatcher{
+		matcher: matcher,
+		min:     int(min),
+	}
+}
+
+// minLabelsMatcher matches only domains with at least min labels.
+type minLabelsMatcher struct {
+	matcher domainMatcher
+	min     int
+}
+
+func (m *minLabelsMatcher) Apply(domain string) bool {
+	return strings.Count(domain, ".")+1 >= m.min && m.matcher.Apply(domain)
+}
+
+// WildcardDomainMatcher matches subdomains of a domain, but not the domain itself.
+type WildcardDomainMatcher string
+
+func NewWildcardDomainMatcher(p string) WildcardDomainMatcher {
+	return WildcardDomainMatcher(strings.TrimPrefix(p, "*."))
+}
+
+func (m WildcardDomainMatcher) Apply(domain string) bool {
+	pattern := string(m)
+	return len(domain) > len(pattern) && strings.HasSuffix(domain, pattern) && domain[len(domain)-len(pattern)-1] == '.'
+}
+
 type CIDRMatcher struct {
 	cidr     *net.IPNet
 	onSource bool
@@ -350,6 +536,30 @@ func (v *PortMatcher) Apply(ctx context.Context) bool {
 	return v.port.Contains(dest.Port)
 }
 
+// PortListMatcher matches destination ports in any of the given ranges.
+type PortListMatcher struct {
+	ranges []net.PortRange
+}
+
+func NewPortListMatcher(ranges []net.PortRange) *PortListMatcher {
+	return &PortListMatcher{
+		ranges: ranges,
+	}
+}
+
+func (m *PortListMatcher) Apply(ctx context.Context) bool {
+	dest, ok := proxy.TargetFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, pr := range m.ranges {
+		if pr.Contains(dest.Port) {
+			return true
+		}
+	}
+	return false
+}
+
 type NetworkMatcher struct {
 	network *net.NetworkList
 }
@@ -397,6 +607,701 @@ func (v *UserMatcher) Apply(ctx context.Context) bool {
 	return false
 }
 
+// SourceUserMatcher matches the username a client authenticated to the inbound with.
+type SourceUserMatcher struct {
+	users []string
+}
+
+func NewSourceUserMatcher(users []string) *SourceUserMatcher {
+	usersCopy := make([]string, 0, len(users))
+	for _, user := range users {
+		if len(user) > 0 {
+			usersCopy = append(usersCopy, user)
+		}
+	}
+	return &SourceUserMatcher{
+		users: usersCopy,
+	}
+}
+
+func (m *SourceUserMatcher) Apply(ctx context.Context) bool {
+	username, ok := proxy.InboundUsernameFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, u := range m.users {
+		if u == username {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsSniffResult is implemented by the sniffing result of TLS connections.
+type tlsSniffResult interface {
+	JA3() string
+	Version() uint16
+}
+
+// httpSniffResult is implemented by the sniffing result of HTTP requests.
+type httpSniffResult interface {
+	Method() string
+	Header(name string) (string, bool)
+}
+
+// JA3Matcher matches the JA3 fingerprint of a sniffed TLS ClientHello.
+type JA3Matcher struct {
+	fingerprints []string
+}
+
+func NewJA3Matcher(fingerprints []string) *JA3Matcher {
+	fingerprintsCopy := make([]string, 0, len(fingerprints))
+	for _, f := range fingerprints {
+		if len(f) > 0 {
+			fingerprintsCopy = append(fingerprintsCopy, strings.ToLower(f))
+		}
+	}
+	return &JA3Matcher{
+		fingerprints: fingerprintsCopy,
+	}
+}
+
+func (m *JA3Matcher) Apply(ctx context.Context) bool {
+	header, ok := proxy.SniffingResultFromContext(ctx).(tlsSniffResult)
+	if !ok {
+		return false
+	}
+	ja3 := header.JA3()
+	for _, f := range m.fingerprints {
+		if f == ja3 {
+			return true
+		}
+	}
+	return false
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": 0x0301,
+	"1.1": 0x0302,
+	"1.2": 0x0303,
+	"1.3": 0x0304,
+}
+
+// TLSVersionMatcher matches sniffed TLS connections by the highest version offered by the client.
+type TLSVersionMatcher struct {
+	versions []uint16
+}
+
+func NewTLSVersionMatcher(versions []string) (*TLSVersionMatcher, error) {
+	m := &TLSVersionMatcher{
+		versions: make([]uint16, 0, len(versions)),
+	}
+	for _, v := range versions {
+		version, found := tlsVersions[v]
+		if !found {
+			return nil, newError("unknown TLS version: ", v).AtWarning()
+		}
+		m.versions = append(m.versions, version)
+	}
+	return m, nil
+}
+
+func (m *TLSVersionMatcher) Apply(ctx context.Context) bool {
+	header, ok := proxy.SniffingResultFromContext(ctx).(tlsSniffResult)
+	if !ok {
+		return false
+	}
+	version := header.Version()
+	for _, v := range m.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPMethodMatcher matches the method of a sniffed HTTP request.
+type HTTPMethodMatcher struct {
+	methods []string
+}
+
+func NewHTTPMethodMatcher(methods []string) *HTTPMethodMatcher {
+	methodsCopy := make([]string, 0, len(methods))
+	for _, m := range methods {
+		if len(m) > 0 {
+			methodsCopy = append(methodsCopy, strings.ToUpper(m))
+		}
+	}
+	return &HTTPMethodMatcher{
+		methods: methodsCopy,
+	}
+}
+
+func (m *HTTPMethodMatcher) Apply(ctx context.Context) bool {
+	header, ok := proxy.SniffingResultFromContext(ctx).(httpSniffResult)
+	if !ok {
+		return false
+	}
+	method := header.Method()
+	for _, v := range m.methods {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPHeaderMatcher matches sniffed HTTP requests that have any of the given headers.
+type HTTPHeaderMatcher struct {
+	headers []*HTTPHeader
+}
+
+func NewHTTPHeaderMatcher(headers []*HTTPHeader) *HTTPHeaderMatcher {
+	return &HTTPHeaderMatcher{
+		headers: headers,
+	}
+}
+
+func (m *HTTPHeaderMatcher) Apply(ctx context.Context) bool {
+	header, ok := proxy.SniffingResultFromContext(ctx).(httpSniffResult)
+	if !ok {
+		return false
+	}
+	for _, h := range m.headers {
+		value, found := header.Header(h.Name)
+		if found && (len(h.Value) == 0 || value == h.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceConnectionsMatcher matches when the source has at least the given number of concurrent connections.
+type SourceConnectionsMatcher struct {
+	min int
+}
+
+func NewSourceConnectionsMatcher(min uint32) *SourceConnectionsMatcher {
+	return &SourceConnectionsMatcher{
+		min: int(min),
+	}
+}
+
+func (m *SourceConnectionsMatcher) Apply(ctx context.Context) bool {
+	n, ok := sourceConnectionsFromContext(ctx)
+	return ok && n >= m.min
+}
+
+// OutboundCapacityMatcher matches while the outbound has fewer active connections than the limit.
+// Only connections routed by the router are counted.
+type OutboundCapacityMatcher struct {
+	tag string
+	max int
+}
+
+func NewOutboundCapacityMatcher(tag string, max uint32) *OutboundCapacityMatcher {
+	return &OutboundCapacityMatcher{
+		tag: tag,
+		max: int(max),
+	}
+}
+
+func (m *OutboundCapacityMatcher) Apply(ctx context.Context) bool {
+	counter := outboundConnectionsFromContext(ctx)
+	if counter == nil {
+		return true
+	}
+	return counter.Count(m.tag) < m.max
+}
+
+// SniffResultMatcher matches connections by whether they were sniffed.
+type SniffResultMatcher struct {
+	sniffed bool
+}
+
+func NewSniffResultMatcher(sniffed bool) *SniffResultMatcher {
+	return &SniffResultMatcher{
+		sniffed: sniffed,
+	}
+}
+
+func (m *SniffResultMatcher) Apply(ctx context.Context) bool {
+	return (proxy.SniffingResultFromContext(ctx) != nil) == m.sniffed
+}
+
+// DirectProbeMatcher matches TCP destinations that accept a direct connection within the timeout.
+// Domain destinations are probed at the first IP resolved by the router, if the rule resolves domains.
+type DirectProbeMatcher struct {
+	sync.Mutex
+	timeout  time.Duration
+	ttl      time.Duration
+	cache    map[string]timedResult
+	inflight map[string]*probeCall
+	lastScan time.Time
+}
+
+// probeCall is a probe in progress. Connections to the same destination wait for it instead of probing again.
+type probeCall struct {
+	done   chan struct{}
+	result bool
+}
+
+func NewDirectProbeMatcher(timeout time.Duration, ttl time.Duration) *DirectProbeMatcher {
+	return &DirectProbeMatcher{
+		timeout:  timeout,
+		ttl:      ttl,
+		cache:    make(map[string]timedResult, 64),
+		inflight: make(map[string]*probeCall),
+	}
+}
+
+// put caches a probe result. It must be called with the lock held.
+func (m *DirectProbeMatcher) put(addr string, result bool, now time.Time) {
+	m.cache[addr] = timedResult{
+		timestamp: now,
+		result:    result,
+	}
+	if now.Sub(m.lastScan) > m.ttl {
+		for k, v := range m.cache {
+			if now.Sub(v.timestamp) > m.ttl {
+				delete(m.cache, k)
+			}
+		}
+		m.lastScan = now
+	}
+}
+
+func (m *DirectProbeMatcher) probe(ctx context.Context, dest net.Destination) bool {
+	addr := dest.NetAddr()
+
+	m.Lock()
+	if r, found := m.cache[addr]; found && time.Since(r.timestamp) <= m.ttl {
+		m.Unlock()
+		return r.result
+	}
+	if isDryRun(ctx) {
+		m.Unlock()
+		return false
+	}
+	if call, found := m.inflight[addr]; found {
+		m.Unlock()
+		select {
+		case <-call.done:
+			return call.result
+		case <-ctx.Done():
+			return false
+		}
+	}
+	call := &probeCall{
+		done: make(chan struct{}),
+	}
+	m.inflight[addr] = call
+	m.Unlock()
+
+	probeCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	conn, err := internet.DialSystem(probeCtx, nil, dest)
+	cancel()
+	if err == nil {
+		conn.Close()
+	}
+	call.result = err == nil
+
+	m.Lock()
+	delete(m.inflight, addr)
+	// A probe cut short by the end of the connection says nothing about the destination.
+	if ctx.Err() == nil {
+		m.put(addr, call.result, time.Now())
+	}
+	m.Unlock()
+	close(call.done)
+	return call.result
+}
+
+func (m *DirectProbeMatcher) Apply(ctx context.Context) bool {
+	dest, ok := proxy.TargetFromContext(ctx)
+	if !ok || dest.Network != net.Network_TCP {
+		return false
+	}
+
+	if dest.Address.Family().IsDomain() {
+		resolver, ok := proxy.ResolvedIPsFromContext(ctx)
+		if !ok {
+			return false
+		}
+		ips := resolver.Resolve()
+		if len(ips) == 0 {
+			return false
+		}
+		dest.Address = ips[0]
+	}
+	return m.probe(ctx, dest)
+}
+
+// ResolutionFailedMatcher matches domain destinations for which the router
+// tried to resolve IPs but got nothing back.
+type ResolutionFailedMatcher struct{}
+
+func NewResolutionFailedMatcher() *ResolutionFailedMatcher {
+	return &ResolutionFailedMatcher{}
+}
+
+func (*ResolutionFailedMatcher) Apply(ctx context.Context) bool {
+	dest, ok := proxy.TargetFromContext(ctx)
+	if !ok || !dest.Address.Family().IsDomain() {
+		return false
+	}
+
+	resolver, ok := proxy.ResolvedIPsFromContext(ctx)
+	if !ok {
+		return false
+	}
+	return len(resolver.Resolve()) == 0
+}
+
+// NotProtocolMatcher matches connections whose sniffed protocol is not in the given list.
+type NotProtocolMatcher struct {
+	protocols []string
+}
+
+func NewNotProtocolMatcher(protocols []string) *NotProtocolMatcher {
+	protocolsCopy := make([]string, 0, len(protocols))
+	for _, p := range protocols {
+		if len(p) > 0 {
+			protocolsCopy = append(protocolsCopy, strings.ToLower(p))
+		}
+	}
+	return &NotProtocolMatcher{
+		protocols: protocolsCopy,
+	}
+}
+
+func (m *NotProtocolMatcher) Apply(ctx context.Context) bool {
+	result := proxy.SniffingResultFromContext(ctx)
+	if result == nil {
+		return true
+	}
+
+	protocol := strings.ToLower(result.Protocol())
+	for _, p := range m.protocols {
+		if p == protocol {
+			return false
+		}
+	}
+	return true
+}
+
+// HostnameEntropyMatcher matches domains that have a label with Shannon entropy above the threshold.
+type HostnameEntropyMatcher struct {
+	threshold float64
+	minLength int
+}
+
+func NewHostnameEntropyMatcher(threshold float64, minLength uint32) *HostnameEntropyMatcher {
+	return &HostnameEntropyMatcher{
+		threshold: threshold,
+		minLength: int(minLength),
+	}
+}
+
+func (m *HostnameEntropyMatcher) Apply(ctx context.Context) bool {
+	dest, ok := proxy.TargetFromContext(ctx)
+	if !ok || !dest.Address.Family().IsDomain() {
+		return false
+	}
+	for _, label := range strings.Split(strings.ToLower(dest.Address.Domain()), ".") {
+		if len(label) >= m.minLength && labelEntropy(label) > m.threshold {
+			return true
+		}
+	}
+	return false
+}
+
+func labelEntropy(label string) float64 {
+	var counts [256]int
+	for i := 0; i < len(label); i++ {
+		counts[label[i]]++
+	}
+	n := float64(len(label))
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// SameSubnetMatcher matches when the destination IP is in the same subnet as the source IP.
+type SameSubnetMatcher struct {
+	ipv4Mask net.IPMask
+	ipv6Mask net.IPMask
+}
+
+func NewSameSubnetMatcher(ipv4Prefix uint32, ipv6Prefix uint32) *SameSubnetMatcher {
+	return &SameSubnetMatcher{
+		ipv4Mask: net.CIDRMask(int(ipv4Prefix), 8*net.IPv4len),
+		ipv6Mask: net.CIDRMask(int(ipv6Prefix), 8*net.IPv6len),
+	}
+}
+
+func (m *SameSubnetMatcher) Apply(ctx context.Context) bool {
+	src, ok := proxy.SourceFromContext(ctx)
+	if !ok || src.Address.Family().IsDomain() {
+		return false
+	}
+
+	var targets []net.Address
+	if resolver, ok := proxy.ResolvedIPsFromContext(ctx); ok {
+		targets = append(targets, resolver.Resolve()...)
+	}
+	if dest, ok := proxy.TargetFromContext(ctx); ok && !dest.Address.Family().IsDomain() {
+		targets = append(targets, dest.Address)
+	}
+
+	mask := m.ipv4Mask
+	if src.Address.Family().IsIPv6() {
+		mask = m.ipv6Mask
+	}
+	subnet := src.Address.IP().Mask(mask)
+	for _, target := range targets {
+		if target.Family() == src.Address.Family() && target.IP().Mask(mask).Equal(subnet) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitMatcher matches when the hash of the key falls below the given percentage.
+type SplitMatcher struct {
+	key     Split_Key
+	percent uint32
+}
+
+func NewSplitMatcher(key Split_Key, percent uint32) *SplitMatcher {
+	return &SplitMatcher{
+		key:     key,
+		percent: percent,
+	}
+}
+
+func (m *SplitMatcher) splitKey(ctx context.Context) (string, bool) {
+	switch m.key {
+	case Split_SourceIP:
+		if src, ok := proxy.SourceFromContext(ctx); ok {
+			return src.Address.String(), true
+		}
+	case Split_Source:
+		if src, ok := proxy.SourceFromContext(ctx); ok {
+			return src.NetAddr(), true
+		}
+	case Split_Target:
+		if dest, ok := proxy.TargetFromContext(ctx); ok {
+			return dest.NetAddr(), true
+		}
+	}
+	return "", false
+}
+
+func (m *SplitMatcher) Apply(ctx context.Context) bool {
+	key, ok := m.splitKey(ctx)
+	if !ok {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%100 < m.percent
+}
+
+type seenSource struct {
+	ip   string
+	seen time.Time
+}
+
+// FirstSeenMatcher matches sources that were not seen within the window. A source is seen when
+// it matches. It remembers a bounded number of sources, forgetting the least recently seen first.
+type FirstSeenMatcher struct {
+	sync.Mutex
+	window   time.Duration
+	capacity int
+	sources  map[string]*list.Element
+	order    *list.List
+}
+
+func NewFirstSeenMatcher(window time.Duration, capacity uint32) *FirstSeenMatcher {
+	return &FirstSeenMatcher{
+		window:   window,
+		capacity: int(capacity),
+		sources:  make(map[string]*list.Element, 64),
+		order:    list.New(),
+	}
+}
+
+func sourceIPFromContext(ctx context.Context) (string, bool) {
+	src, ok := proxy.SourceFromContext(ctx)
+	if !ok || src.Address.Family().IsDomain() {
+		return "", false
+	}
+	return src.Address.String(), true
+}
+
+// unseen returns true if ip was not seen within the window. It must be called with the lock held.
+func (m *FirstSeenMatcher) unseen(ip string, now time.Time) bool {
+	e, found := m.sources[ip]
+	return !found || now.Sub(e.Value.(*seenSource).seen) > m.window
+}
+
+// Check implements statefulCondition.
+func (m *FirstSeenMatcher) Check(ctx context.Context) bool {
+	ip, ok := sourceIPFromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	return m.unseen(ip, time.Now())
+}
+
+// Commit implements statefulCondition. It records the source as seen.
+func (m *FirstSeenMatcher) Commit(ctx context.Context) bool {
+	ip, ok := sourceIPFromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	if !m.unseen(ip, now) {
+		return false
+	}
+	if e, found := m.sources[ip]; found {
+		e.Value.(*seenSource).seen = now
+		m.order.MoveToFront(e)
+		return true
+	}
+
+	m.sources[ip] = m.order.PushFront(&seenSource{ip: ip, seen: now})
+	if m.order.Len() > m.capacity {
+		oldest := m.order.Remove(m.order.Back()).(*seenSource)
+		delete(m.sources, oldest.ip)
+	}
+	return true
+}
+
+func (m *FirstSeenMatcher) Apply(ctx context.Context) bool {
+	if isDryRun(ctx) {
+		return m.Check(ctx)
+	}
+	return m.Commit(ctx)
+}
+
+// RateLimitMatcher is a token bucket that matches as long as there are tokens left.
+type RateLimitMatcher struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimitMatcher(rate uint32, burst uint32) *RateLimitMatcher {
+	if burst == 0 {
+		burst = rate
+	}
+	return &RateLimitMatcher{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// available returns the tokens in the bucket at the given time. It must be called with the lock held.
+func (m *RateLimitMatcher) available(now time.Time) float64 {
+	tokens := m.tokens + now.Sub(m.last).Seconds()*m.rate
+	if tokens > m.burst {
+		tokens = m.burst
+	}
+	return tokens
+}
+
+// Check implements statefulCondition.
+func (m *RateLimitMatcher) Check(ctx context.Context) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.available(time.Now()) >= 1
+}
+
+// Commit implements statefulCondition. It takes a token from the bucket.
+func (m *RateLimitMatcher) Commit(ctx context.Context) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	m.tokens = m.available(now)
+	m.last = now
+
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+func (m *RateLimitMatcher) Apply(ctx context.Context) bool {
+	if isDryRun(ctx) {
+		return m.Check(ctx)
+	}
+	return m.Commit(ctx)
+}
+
+// transportNames maps accepted transport names to the names used by inbounds.
+var transportNames = map[string]string{
+	"tcp":       "tcp",
+	"udp":       "udp",
+	"kcp":       "mkcp",
+	"mkcp":      "mkcp",
+	"ws":        "websocket",
+	"websocket": "websocket",
+}
+
+// InboundTransportMatcher matches connections by the transport they arrived over.
+type InboundTransportMatcher struct {
+	transports []string
+}
+
+func NewInboundTransportMatcher(transports []string) (*InboundTransportMatcher, error) {
+	m := &InboundTransportMatcher{
+		transports: make([]string, 0, len(transports)),
+	}
+	for _, t := range transports {
+		name, found := transportNames[strings.ToLower(t)]
+		if !found {
+			return nil, newError("unknown transport: ", t).AtWarning()
+		}
+		m.transports = append(m.transports, name)
+	}
+	return m, nil
+}
+
+func (m *InboundTransportMatcher) Apply(ctx context.Context) bool {
+	transport, ok := proxy.InboundTransportFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, t := range m.transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
 type InboundTagMatcher struct {
 	tags []string
 }
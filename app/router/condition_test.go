@@ -2,13 +2,19 @@ package router_test
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	proto "github.com/golang/protobuf/proto"
+	"v2ray.com/core/app/dispatcher"
 	. "v2ray.com/core/app/router"
 	"v2ray.com/core/common"
 	"v2ray.com/core/common/errors"
@@ -60,6 +66,352 @@ func TestSubDomainMatcher(t *testing.T) {
 	}
 }
 
+func TestWildcardDomainMatcher(t *testing.T) {
+	assert := With(t)
+
+	cases := []struct {
+		domain *Domain
+		input  string
+		output bool
+	}{
+		{&Domain{Type: Domain_Wildcard, Value: "v2ray.com"}, "www.v2ray.com", true},
+		{&Domain{Type: Domain_Wildcard, Value: "v2ray.com"}, "a.b.v2ray.com", true},
+		{&Domain{Type: Domain_Wildcard, Value: "v2ray.com"}, "v2ray.com", false},
+		{&Domain{Type: Domain_Wildcard, Value: "v2ray.com"}, "xv2ray.com", false},
+		{&Domain{Type: Domain_Wildcard, Value: "*.v2ray.com"}, "www.v2ray.com", true},
+		{&Domain{Type: Domain_Wildcard, Value: "*.v2ray.com"}, "v2ray.com", false},
+		{&Domain{Type: Domain_Domain, Value: "*.v2ray.com"}, "www.v2ray.com", true},
+		{&Domain{Type: Domain_Domain, Value: "*.v2ray.com"}, "v2ray.com", false},
+		{&Domain{Type: Domain_Domain, Value: "v2ray.com"}, "www.v2ray.com", true},
+		{&Domain{Type: Domain_Domain, Value: "v2ray.com"}, "v2ray.com", true},
+	}
+	for _, test := range cases {
+		cond, err := (&RoutingRule{Domain: []*Domain{test.domain}}).BuildCondition()
+		assert(err, IsNil)
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(test.input), 80))
+		assert(cond.Apply(ctx), Equals, test.output)
+	}
+}
+
+func TestDomainMinLabels(t *testing.T) {
+	assert := With(t)
+
+	cases := []struct {
+		domain *Domain
+		input  string
+		output bool
+	}{
+		{&Domain{Type: Domain_Domain, Value: "cn", MinLabels: 3}, "www.example.cn", true},
+		{&Domain{Type: Domain_Domain, Value: "cn", MinLabels: 3}, "example.cn", false},
+		{&Domain{Type: Domain_Domain, Value: "cn", MinLabels: 3}, "cn", false},
+		{&Domain{Type: Domain_Domain, Value: "cn"}, "example.cn", true},
+		{&Domain{Type: Domain_Domain, Value: "example.com", MinLabels: 2}, "example.com", true},
+		{&Domain{Type: Domain_Domain, Value: "example.com", MinLabels: 2}, "www.example.com", true},
+		{&Domain{Type: Domain_Domain, Value: "example.com", MinLabels: 2}, "example.org", false},
+		{&Domain{Type: Domain_Domain, Value: "*.com", MinLabels: 3}, "example.com", false},
+		{&Domain{Type: Domain_Domain, Value: "*.com", MinLabels: 3}, "www.example.com", true},
+		{&Domain{Type: Domain_Wildcard, Value: "cn", MinLabels: 3}, "example.cn", false},
+		{&Domain{Type: Domain_Wildcard, Value: "cn", MinLabels: 3}, "www.example.cn", true},
+	}
+	for _, test := range cases {
+		cond, err := (&RoutingRule{Domain: []*Domain{test.domain}}).BuildCondition()
+		assert(err, IsNil)
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(test.input), 80))
+		assert(cond.Apply(ctx), Equals, test.output)
+	}
+}
+
+func TestHostnameEntropy(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{HostnameEntropy: &HostnameEntropy{Threshold: 3.6}}).BuildCondition()
+	assert(err, IsNil)
+
+	cases := []struct {
+		input  string
+		output bool
+	}{
+		{"mail.google.com", false},
+		{"googleusercontent.com", false},
+		{"x7kq9zp2mv4rt8wb.com", true},
+		{"www.QWHE8F7A6SDKJH2.net", true},
+		{"a1b2c3d.com", false},
+	}
+	for _, test := range cases {
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(test.input), 80))
+		assert(cond.Apply(ctx), Equals, test.output)
+	}
+
+	ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("1.2.3.4"), 80))
+	assert(cond.Apply(ctx), IsFalse)
+
+	cond, err = (&RoutingRule{HostnameEntropy: &HostnameEntropy{Threshold: 2.5, MinLength: 4}}).BuildCondition()
+	assert(err, IsNil)
+	ctx = proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("a1b2c3d.com"), 80))
+	assert(cond.Apply(ctx), IsTrue)
+
+	_, err = (&RoutingRule{HostnameEntropy: &HostnameEntropy{}}).BuildCondition()
+	assert(err, IsNotNil)
+}
+
+func TestInboundTransportMatcher(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{InboundTransport: []string{"ws", "KCP"}}).BuildCondition()
+	assert(err, IsNil)
+
+	cases := []struct {
+		transport string
+		output    bool
+	}{
+		{"websocket", true},
+		{"mkcp", true},
+		{"tcp", false},
+		{"udp", false},
+	}
+	for _, test := range cases {
+		ctx := proxy.ContextWithInboundTransport(context.Background(), test.transport)
+		assert(cond.Apply(ctx), Equals, test.output)
+	}
+	assert(cond.Apply(context.Background()), IsFalse)
+
+	_, err = (&RoutingRule{InboundTransport: []string{"grpc"}}).BuildCondition()
+	assert(err, IsNotNil)
+}
+
+func TestPortBucket(t *testing.T) {
+	assert := With(t)
+
+	cases := []struct {
+		buckets []string
+		port    net.Port
+		output  bool
+	}{
+		{[]string{"well-known"}, 0, true},
+		{[]string{"well-known"}, 1023, true},
+		{[]string{"well-known"}, 1024, false},
+		{[]string{"registered"}, 1023, false},
+		{[]string{"registered"}, 1024, true},
+		{[]string{"registered"}, 49151, true},
+		{[]string{"registered"}, 49152, false},
+		{[]string{"dynamic"}, 49151, false},
+		{[]string{"dynamic"}, 49152, true},
+		{[]string{"dynamic"}, 65535, true},
+		{[]string{"well-known", "dynamic"}, 80, true},
+		{[]string{"well-known", "dynamic"}, 8080, false},
+	}
+	for _, test := range cases {
+		cond, err := (&RoutingRule{PortBucket: test.buckets}).BuildCondition()
+		assert(err, IsNil)
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.LocalHostIP, test.port))
+		assert(cond.Apply(ctx), Equals, test.output)
+	}
+
+	_, err := (&RoutingRule{PortBucket: []string{"private"}}).BuildCondition()
+	assert(err, IsNotNil)
+}
+
+func TestDirectProbe(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{DirectProbe: &DirectProbe{}}).BuildCondition()
+	assert(err, IsNil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	reachable := net.DestinationFromAddr(listener.Addr())
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	unreachable := net.DestinationFromAddr(closed.Addr())
+	common.Must(closed.Close())
+
+	assert(cond.Apply(proxy.ContextWithTarget(context.Background(), reachable)), IsTrue)
+	assert(cond.Apply(proxy.ContextWithTarget(context.Background(), unreachable)), IsFalse)
+	assert(cond.Apply(proxy.ContextWithTarget(context.Background(), net.UDPDestination(reachable.Address, reachable.Port))), IsFalse)
+
+	// Results are cached, so the destination still matches after it goes away.
+	common.Must(listener.Close())
+	assert(cond.Apply(proxy.ContextWithTarget(context.Background(), reachable)), IsTrue)
+
+	cond, err = (&RoutingRule{DirectProbe: &DirectProbe{}}).BuildCondition()
+	assert(err, IsNil)
+	assert(cond.Apply(proxy.ContextWithTarget(context.Background(), reachable)), IsFalse)
+}
+
+func TestDirectProbeDomain(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{DirectProbe: &DirectProbe{}}).BuildCondition()
+	assert(err, IsNil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+	reachable := net.DestinationFromAddr(listener.Addr())
+
+	domain := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("localhost"), reachable.Port))
+
+	// Domains are not resolved by the probe itself.
+	assert(cond.Apply(domain), IsFalse)
+	assert(cond.Apply(proxy.ContextWithResolveIPs(domain, staticResolver{})), IsFalse)
+	assert(cond.Apply(proxy.ContextWithResolveIPs(domain, staticResolver{reachable.Address})), IsTrue)
+}
+
+func TestDirectProbeCanceled(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{DirectProbe: &DirectProbe{}}).BuildCondition()
+	assert(err, IsNil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+	reachable := net.DestinationFromAddr(listener.Addr())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert(cond.Apply(proxy.ContextWithTarget(ctx, reachable)), IsFalse)
+
+	// The result of a canceled probe is not cached.
+	assert(cond.Apply(proxy.ContextWithTarget(context.Background(), reachable)), IsTrue)
+}
+
+func TestDirectProbeConcurrent(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{DirectProbe: &DirectProbe{Timeout: 1000}}).BuildCondition()
+	assert(err, IsNil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	common.Must(err)
+	defer listener.Close()
+	reachable := net.DestinationFromAddr(listener.Addr())
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			conn.Close()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var matched int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cond.Apply(proxy.ContextWithTarget(context.Background(), reachable)) {
+				atomic.AddInt32(&matched, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	time.Sleep(100 * time.Millisecond)
+
+	assert(atomic.LoadInt32(&matched), Equals, int32(16))
+	assert(atomic.LoadInt32(&accepted), Equals, int32(1))
+}
+
+func TestFirstSeenWithRateLimit(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{
+		FirstSeen: &FirstSeen{},
+		RateLimit: &RateLimit{Rate: 1},
+	}).BuildCondition()
+	assert(err, IsNil)
+
+	apply := func(ip string) bool {
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress(ip), 10000))
+		return cond.Apply(ctx)
+	}
+
+	assert(apply("10.0.0.1"), IsTrue)
+	assert(apply("10.0.0.2"), IsFalse)
+
+	// 10.0.0.2 was rejected by the rate limit, so it is not recorded as seen.
+	time.Sleep(1100 * time.Millisecond)
+	assert(apply("10.0.0.2"), IsTrue)
+	assert(apply("10.0.0.2"), IsFalse)
+}
+
+func TestConditionChanCommitsOnMatch(t *testing.T) {
+	assert := With(t)
+
+	firstSeen := NewFirstSeenMatcher(time.Hour, 16)
+	cond := NewConditionChan().Add(NewRateLimitMatcher(1, 2)).Add(firstSeen).Add(NewInboundTagMatcher([]string{"in"}))
+
+	apply := func(ip string, tag string) bool {
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress(ip), 10000))
+		return cond.Apply(proxy.ContextWithInboundTag(ctx, tag))
+	}
+
+	// The inbound tag doesn't match, so no token is taken and the source is not recorded.
+	assert(apply("10.0.0.1", "out"), IsFalse)
+	assert(apply("10.0.0.1", "in"), IsTrue)
+
+	// The source was seen, so no token is taken.
+	assert(apply("10.0.0.1", "in"), IsFalse)
+	assert(apply("10.0.0.2", "in"), IsTrue)
+
+	// There are no tokens left, so the source is not recorded.
+	assert(apply("10.0.0.3", "in"), IsFalse)
+	assert(firstSeen.Check(proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress("10.0.0.3"), 10000))), IsTrue)
+}
+
+func TestFirstSeen(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{FirstSeen: &FirstSeen{Capacity: 2}}).BuildCondition()
+	assert(err, IsNil)
+
+	apply := func(ip string) bool {
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress(ip), 10000))
+		return cond.Apply(ctx)
+	}
+
+	assert(apply("10.0.0.1"), IsTrue)
+	assert(apply("10.0.0.1"), IsFalse)
+	assert(apply("10.0.0.2"), IsTrue)
+	assert(apply("10.0.0.1"), IsFalse)
+	assert(apply("10.0.0.2"), IsFalse)
+
+	// 10.0.0.1 is the least recently seen, so it is forgotten first.
+	assert(apply("10.0.0.3"), IsTrue)
+	assert(apply("10.0.0.2"), IsFalse)
+	assert(apply("10.0.0.1"), IsTrue)
+
+	assert(cond.Apply(context.Background()), IsFalse)
+}
+
+func TestFirstSeenWindow(t *testing.T) {
+	assert := With(t)
+
+	m := NewFirstSeenMatcher(time.Millisecond*50, 16)
+	ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress("10.0.0.1"), 10000))
+
+	assert(m.Apply(ctx), IsTrue)
+	assert(m.Apply(ctx), IsFalse)
+	time.Sleep(time.Millisecond * 100)
+	assert(m.Apply(ctx), IsTrue)
+}
+
+type sniffResult string
+
+func (r sniffResult) Protocol() string {
+	return string(r)
+}
+
+func (sniffResult) Domain() string {
+	return "v2ray.com"
+}
+
 func TestRoutingRule(t *testing.T) {
 	assert := With(t)
 
@@ -177,6 +529,98 @@ func TestRoutingRule(t *testing.T) {
 				},
 			},
 		},
+		{
+			rule: &RoutingRule{
+				SourceUser: []string{"alice"},
+			},
+			test: []ruleTest{
+				{
+					input:  proxy.ContextWithInboundUsername(context.Background(), "alice"),
+					output: true,
+				},
+				{
+					input:  proxy.ContextWithInboundUsername(context.Background(), "bob"),
+					output: false,
+				},
+				{
+					input:  protocol.ContextWithUser(context.Background(), &protocol.User{Email: "alice"}),
+					output: false,
+				},
+				{
+					input:  context.Background(),
+					output: false,
+				},
+			},
+		},
+		{
+			rule: &RoutingRule{
+				SniffResult: RoutingRule_Sniffed,
+			},
+			test: []ruleTest{
+				{
+					input:  proxy.ContextWithSniffingResult(context.Background(), sniffResult("tls")),
+					output: true,
+				},
+				{
+					input:  context.Background(),
+					output: false,
+				},
+			},
+		},
+		{
+			rule: &RoutingRule{
+				SniffResult: RoutingRule_Unsniffed,
+			},
+			test: []ruleTest{
+				{
+					input:  proxy.ContextWithSniffingResult(context.Background(), sniffResult("http")),
+					output: false,
+				},
+				{
+					input:  context.Background(),
+					output: true,
+				},
+			},
+		},
+		{
+			rule: &RoutingRule{
+				SniffResult: RoutingRule_Any,
+				InboundTag:  []string{"in"},
+			},
+			test: []ruleTest{
+				{
+					input:  proxy.ContextWithSniffingResult(proxy.ContextWithInboundTag(context.Background(), "in"), sniffResult("http")),
+					output: true,
+				},
+				{
+					input:  proxy.ContextWithInboundTag(context.Background(), "in"),
+					output: true,
+				},
+			},
+		},
+		{
+			rule: &RoutingRule{
+				NotProtocol: []string{"bittorrent"},
+			},
+			test: []ruleTest{
+				{
+					input:  proxy.ContextWithSniffingResult(context.Background(), sniffResult("bittorrent")),
+					output: false,
+				},
+				{
+					input:  proxy.ContextWithSniffingResult(context.Background(), sniffResult("tls")),
+					output: true,
+				},
+				{
+					input:  proxy.ContextWithSniffingResult(context.Background(), sniffResult("http")),
+					output: true,
+				},
+				{
+					input:  context.Background(),
+					output: true,
+				},
+			},
+		},
 	}
 
 	for _, test := range cases {
@@ -189,6 +633,202 @@ func TestRoutingRule(t *testing.T) {
 	}
 }
 
+func buildClientHello(serverName string, cipherSuites ...uint16) []byte {
+	hello := []byte{0x03, 0x03}
+	hello = append(hello, make([]byte, 32)...) // random
+	hello = append(hello, 0)                   // session id
+	cipherSuitesLen := len(cipherSuites) * 2
+	hello = append(hello, byte(cipherSuitesLen>>8), byte(cipherSuitesLen))
+	for _, c := range cipherSuites {
+		hello = append(hello, byte(c>>8), byte(c))
+	}
+	hello = append(hello, 1, 0) // compression methods
+
+	sni := []byte{0, byte(len(serverName) + 3), 0, 0, byte(len(serverName))}
+	sni = append(sni, serverName...)
+	hello = append(hello, 0, byte(len(sni)+4), 0, 0, 0, byte(len(sni)))
+	hello = append(hello, sni...)
+
+	handshake := []byte{0x01, 0, byte(len(hello) >> 8), byte(len(hello))}
+	handshake = append(handshake, hello...)
+	record := []byte{0x16, 0x03, 0x01, byte(len(handshake) >> 8), byte(len(handshake))}
+	return append(record, handshake...)
+}
+
+func TestJA3Rule(t *testing.T) {
+	assert := With(t)
+
+	hash := md5.Sum([]byte("771,4865-49195,0,,"))
+	rule := &RoutingRule{
+		Ja3: []string{strings.ToUpper(hex.EncodeToString(hash[:]))},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	sniff := func(b []byte) context.Context {
+		header, err := dispatcher.SniffTLSHeader(b)
+		common.Must(err)
+		return proxy.ContextWithSniffingResult(context.Background(), header)
+	}
+
+	// GREASE values don't change the fingerprint.
+	assert(cond.Apply(sniff(buildClientHello("v2ray.com", 0x1301, 0xc02b))), IsTrue)
+	assert(cond.Apply(sniff(buildClientHello("v2ray.com", 0x2a2a, 0x1301, 0xc02b))), IsTrue)
+	assert(cond.Apply(sniff(buildClientHello("v2ray.com", 0xc02b, 0x1301))), IsFalse)
+	assert(cond.Apply(sniff(buildClientHello("v2ray.com", 0x1301))), IsFalse)
+	assert(cond.Apply(proxy.ContextWithSniffingResult(context.Background(), sniffResult("http"))), IsFalse)
+	assert(cond.Apply(context.Background()), IsFalse)
+}
+
+// buildVersionedClientHello builds a ClientHello with the given client version, and a
+// supported_versions extension if any supported versions are given.
+func buildVersionedClientHello(version uint16, supported ...uint16) []byte {
+	hello := []byte{byte(version >> 8), byte(version)}
+	hello = append(hello, make([]byte, 32)...) // random
+	hello = append(hello, 0, 0, 2, 0x13, 0x01) // session id, cipher suites
+	hello = append(hello, 1, 0)                // compression methods
+
+	serverName := "v2ray.com"
+	extensions := []byte{0, 0, 0, byte(len(serverName) + 5), 0, byte(len(serverName) + 3), 0, 0, byte(len(serverName))}
+	extensions = append(extensions, serverName...)
+	if len(supported) > 0 {
+		extensions = append(extensions, 0, 0x2b, 0, byte(len(supported)*2+1), byte(len(supported)*2))
+		for _, v := range supported {
+			extensions = append(extensions, byte(v>>8), byte(v))
+		}
+	}
+	hello = append(hello, 0, byte(len(extensions)))
+	hello = append(hello, extensions...)
+
+	handshake := []byte{0x01, 0, byte(len(hello) >> 8), byte(len(hello))}
+	handshake = append(handshake, hello...)
+	record := []byte{0x16, 0x03, 0x01, byte(len(handshake) >> 8), byte(len(handshake))}
+	return append(record, handshake...)
+}
+
+func TestTLSVersionRule(t *testing.T) {
+	assert := With(t)
+
+	rule := &RoutingRule{
+		TlsVersion: []string{"1.0", "1.1"},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	sniff := func(b []byte) context.Context {
+		header, err := dispatcher.SniffTLSHeader(b)
+		common.Must(err)
+		return proxy.ContextWithSniffingResult(context.Background(), header)
+	}
+
+	assert(cond.Apply(sniff(buildVersionedClientHello(0x0301))), IsTrue)
+	assert(cond.Apply(sniff(buildVersionedClientHello(0x0302))), IsTrue)
+	assert(cond.Apply(sniff(buildVersionedClientHello(0x0303))), IsFalse)
+	// TLS 1.3 clients offer 1.2 as client version and 1.3 in the extension.
+	assert(cond.Apply(sniff(buildVersionedClientHello(0x0303, 0x0a0a, 0x0304, 0x0303))), IsFalse)
+	assert(cond.Apply(proxy.ContextWithSniffingResult(context.Background(), sniffResult("tls"))), IsFalse)
+	assert(cond.Apply(context.Background()), IsFalse)
+
+	rule = &RoutingRule{
+		TlsVersion: []string{"1.3"},
+	}
+	cond, err = rule.BuildCondition()
+	assert(err, IsNil)
+	assert(cond.Apply(sniff(buildVersionedClientHello(0x0303, 0x0a0a, 0x0304, 0x0303))), IsTrue)
+	assert(cond.Apply(sniff(buildVersionedClientHello(0x0303))), IsFalse)
+
+	_, err = (&RoutingRule{TlsVersion: []string{"2.0"}}).BuildCondition()
+	assert(err, IsNotNil)
+}
+
+func TestHTTPMethodRule(t *testing.T) {
+	assert := With(t)
+
+	rule := &RoutingRule{
+		HttpMethod: []string{"post", "PUT"},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	sniff := func(request string) context.Context {
+		header, err := dispatcher.SniffHTTPHeader([]byte(request))
+		common.Must(err)
+		return proxy.ContextWithSniffingResult(context.Background(), header)
+	}
+
+	assert(cond.Apply(sniff("POST /upload HTTP/1.1\r\nHost: v2ray.com\r\n\r\n")), IsTrue)
+	assert(cond.Apply(sniff("put /upload HTTP/1.1\r\nHost: v2ray.com\r\n\r\n")), IsTrue)
+	assert(cond.Apply(sniff("GET / HTTP/1.1\r\nHost: v2ray.com\r\n\r\n")), IsFalse)
+	assert(cond.Apply(sniff("HEAD / HTTP/1.1\r\nHost: v2ray.com\r\n\r\n")), IsFalse)
+	assert(cond.Apply(proxy.ContextWithSniffingResult(context.Background(), sniffResult("tls"))), IsFalse)
+	assert(cond.Apply(context.Background()), IsFalse)
+}
+
+func TestHTTPHeaderRule(t *testing.T) {
+	assert := With(t)
+
+	rule := &RoutingRule{
+		HttpHeader: []*HTTPHeader{
+			{Name: "authorization"},
+			{Name: "X-Requested-With", Value: "XMLHttpRequest"},
+		},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	sniff := func(request string) context.Context {
+		header, err := dispatcher.SniffHTTPHeader([]byte(request))
+		common.Must(err)
+		return proxy.ContextWithSniffingResult(context.Background(), header)
+	}
+
+	assert(cond.Apply(sniff("GET /api HTTP/1.1\r\nHost: v2ray.com\r\nAuthorization: Bearer x\r\n\r\n")), IsTrue)
+	assert(cond.Apply(sniff("GET /api HTTP/1.1\r\nHost: v2ray.com\r\nAUTHORIZATION: Bearer x\r\n\r\n")), IsTrue)
+	assert(cond.Apply(sniff("GET /api HTTP/1.1\r\nHost: v2ray.com\r\nx-requested-with: XMLHttpRequest\r\n\r\n")), IsTrue)
+	assert(cond.Apply(sniff("GET /api HTTP/1.1\r\nHost: v2ray.com\r\nX-Requested-With: Fetch\r\n\r\n")), IsFalse)
+	assert(cond.Apply(sniff("GET / HTTP/1.1\r\nHost: v2ray.com\r\nUser-Agent: Mozilla\r\n\r\n")), IsFalse)
+	assert(cond.Apply(proxy.ContextWithSniffingResult(context.Background(), sniffResult("http"))), IsFalse)
+	assert(cond.Apply(context.Background()), IsFalse)
+}
+
+func TestRateLimitRule(t *testing.T) {
+	assert := With(t)
+
+	rule := &RoutingRule{
+		InboundTag: []string{"in"},
+		RateLimit: &RateLimit{
+			Rate:  10,
+			Burst: 3,
+		},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	matching := proxy.ContextWithInboundTag(context.Background(), "in")
+	other := proxy.ContextWithInboundTag(context.Background(), "other")
+
+	// Connections not matching other conditions don't take tokens.
+	for i := 0; i < 10; i++ {
+		assert(cond.Apply(other), IsFalse)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert(cond.Apply(matching), IsTrue)
+	}
+	assert(cond.Apply(matching), IsFalse)
+	assert(cond.Apply(matching), IsFalse)
+
+	time.Sleep(time.Millisecond * 150)
+	assert(cond.Apply(matching), IsTrue)
+	assert(cond.Apply(matching), IsFalse)
+
+	time.Sleep(time.Second)
+	for i := 0; i < 3; i++ {
+		assert(cond.Apply(matching), IsTrue)
+	}
+	assert(cond.Apply(matching), IsFalse)
+}
+
 func loadGeoSite(country string) ([]*Domain, error) {
 	geositeBytes, err := sysio.ReadAsset("geosite.dat")
 	if err != nil {
@@ -234,3 +874,300 @@ func TestChinaSites(t *testing.T) {
 		assert(matcher.ApplyDomain(strconv.Itoa(i)+".not-exists2.com"), IsFalse)
 	}
 }
+
+func TestSplitRule(t *testing.T) {
+	assert := With(t)
+
+	rule := &RoutingRule{
+		Split: &Split{
+			Key:     Split_SourceIP,
+			Percent: 20,
+		},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	matched := 0
+	for i := 0; i < 10000; i++ {
+		ip := net.IPAddress([]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(ip, 1234))
+		result := cond.Apply(ctx)
+		for j := 0; j < 3; j++ {
+			assert(cond.Apply(ctx), Equals, result)
+		}
+		if result {
+			matched++
+		}
+	}
+	assert(matched > 1800 && matched < 2200, IsTrue)
+
+	// A connection without a key never matches.
+	assert(cond.Apply(context.Background()), IsFalse)
+
+	_, err = (&RoutingRule{
+		Split: &Split{
+			Percent: 101,
+		},
+	}).BuildCondition()
+	assert(err, IsNotNil)
+}
+
+func TestSourceAndTargetCIDR(t *testing.T) {
+	assert := With(t)
+
+	rule := &RoutingRule{
+		Cidr: []*CIDR{
+			{
+				Ip:     []byte{8, 8, 8, 0},
+				Prefix: 24,
+			},
+		},
+		SourceCidr: []*CIDR{
+			{
+				Ip:     []byte{10, 0, 0, 0},
+				Prefix: 8,
+			},
+		},
+	}
+	cond, err := rule.BuildCondition()
+	assert(err, IsNil)
+
+	cases := []struct {
+		source net.Address
+		target net.Address
+		output bool
+	}{
+		{net.ParseAddress("10.1.2.3"), net.ParseAddress("8.8.8.8"), true},
+		{net.ParseAddress("10.1.2.3"), net.ParseAddress("1.1.1.1"), false},
+		{net.ParseAddress("192.168.1.1"), net.ParseAddress("8.8.8.8"), false},
+		{net.ParseAddress("192.168.1.1"), net.ParseAddress("1.1.1.1"), false},
+	}
+	compiled, err := rule.Build()
+	assert(err, IsNil)
+	for _, test := range cases {
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(test.source, 1234))
+		ctx = proxy.ContextWithTarget(ctx, net.TCPDestination(test.target, 443))
+		assert(cond.Apply(ctx), Equals, test.output)
+		assert(compiled.MatchEndpoints(test.source.IP(), test.target.IP()), Equals, test.output)
+	}
+
+	compiled, err = (&RoutingRule{SourceCidr: rule.SourceCidr}).Build()
+	assert(err, IsNil)
+	assert(compiled.MatchEndpoints(net.ParseIP("10.1.2.3"), net.ParseIP("1.1.1.1")), IsTrue)
+	assert(compiled.MatchEndpoints(net.ParseIP("192.168.1.1"), net.ParseIP("8.8.8.8")), IsFalse)
+
+	compiled, err = (&RoutingRule{PortRange: net.SinglePortRange(443)}).Build()
+	assert(err, IsNil)
+	assert(compiled.MatchEndpoints(net.ParseIP("10.1.2.3"), net.ParseIP("8.8.8.8")), IsFalse)
+}
+
+func TestRuleEvaluators(t *testing.T) {
+	assert := With(t)
+
+	rule, err := (&RoutingRule{
+		Tag: "test",
+		Domain: []*Domain{
+			{
+				Type:  Domain_Domain,
+				Value: "v2ray.com",
+			},
+		},
+		Cidr: []*CIDR{
+			{
+				Ip:     []byte{8, 8, 8, 0},
+				Prefix: 24,
+			},
+		},
+		SourceCidr: []*CIDR{
+			{
+				Ip:     []byte{10, 0, 0, 0},
+				Prefix: 8,
+			},
+		},
+		PortRange:   &net.PortRange{From: 80, To: 443},
+		NetworkList: net.Network_TCP.AsList(),
+	}).Build()
+	assert(err, IsNil)
+	assert(rule.Tag, Equals, "test")
+
+	assert(rule.MatchDomain("www.v2ray.com"), IsTrue)
+	assert(rule.MatchDomain("v2ray.org"), IsFalse)
+	assert(rule.MatchIP(net.ParseIP("8.8.8.8")), IsTrue)
+	assert(rule.MatchIP(net.ParseIP("1.1.1.1")), IsFalse)
+	assert(rule.MatchSourceIP(net.ParseIP("10.2.3.4")), IsTrue)
+	assert(rule.MatchSourceIP(net.ParseIP("192.168.0.1")), IsFalse)
+	assert(rule.MatchPort(net.Port(443)), IsTrue)
+	assert(rule.MatchPort(net.Port(8080)), IsFalse)
+	assert(rule.MatchNetwork(net.Network_TCP), IsTrue)
+	assert(rule.MatchNetwork(net.Network_UDP), IsFalse)
+
+	rule, err = (&RoutingRule{
+		InboundTag: []string{"in"},
+	}).Build()
+	assert(err, IsNil)
+	assert(rule.MatchDomain("v2ray.com"), IsFalse)
+	assert(rule.MatchPort(net.Port(80)), IsFalse)
+}
+
+func TestRegexDomainAnchoring(t *testing.T) {
+	assert := With(t)
+
+	target := func(domain string) context.Context {
+		return proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(domain), 80))
+	}
+
+	cases := []struct {
+		domain *Domain
+		input  string
+		output bool
+	}{
+		{&Domain{Type: Domain_Regex, Value: "google"}, "google", true},
+		{&Domain{Type: Domain_Regex, Value: "google"}, "notgoogle.com", false},
+		{&Domain{Type: Domain_Regex, Value: "google|v2ray\\.com"}, "v2ray.com", true},
+		{&Domain{Type: Domain_Regex, Value: "google|v2ray\\.com"}, "google.com", false},
+		{&Domain{Type: Domain_Regex, Value: "^google"}, "google.com", true},
+		{&Domain{Type: Domain_Regex, Value: "google\\.com$"}, "www.google.com", true},
+		{&Domain{Type: Domain_Regex, Value: "google", Unanchored: true}, "notgoogle.com", true},
+	}
+	for _, test := range cases {
+		cond, err := (&RoutingRule{Domain: []*Domain{test.domain}}).BuildCondition()
+		assert(err, IsNil)
+		assert(cond.Apply(target(test.input)), Equals, test.output)
+	}
+}
+
+func TestCollapseCIDRs(t *testing.T) {
+	assert := With(t)
+
+	cidrs := []*CIDR{
+		{Ip: []byte{192, 168, 1, 0}, Prefix: 24},
+		{Ip: []byte{10, 1, 0, 0}, Prefix: 16},
+		{Ip: []byte{192, 168, 0, 128}, Prefix: 25},
+		{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+		{Ip: []byte{172, 16, 5, 7}, Prefix: 16},
+		{Ip: []byte{192, 168, 0, 0}, Prefix: 25},
+		{Ip: []byte{172, 16, 0, 0}, Prefix: 16},
+		{Ip: net.ParseIP("2001:db8:1::"), Prefix: 48},
+		{Ip: net.ParseIP("2001:db8::"), Prefix: 32},
+		{Ip: []byte{8, 8, 8, 8}, Prefix: 32},
+	}
+
+	collapsed := CollapseCIDRs(cidrs)
+	expected := []*CIDR{
+		{Ip: []byte{8, 8, 8, 8}, Prefix: 32},
+		{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+		{Ip: []byte{172, 16, 0, 0}, Prefix: 16},
+		{Ip: []byte{192, 168, 0, 0}, Prefix: 23},
+		{Ip: net.ParseIP("2001:db8::"), Prefix: 32},
+	}
+	assert(len(collapsed), Equals, len(expected))
+	for i := range expected {
+		assert([]byte(collapsed[i].Ip), Equals, []byte(expected[i].Ip))
+		assert(collapsed[i].Prefix, Equals, expected[i].Prefix)
+	}
+
+	contains := func(list []*CIDR, ip net.IP) bool {
+		for _, c := range list {
+			ipNet := &net.IPNet{IP: c.Ip, Mask: net.CIDRMask(int(c.Prefix), len(c.Ip)*8)}
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	probes := []net.IP{
+		{10, 1, 2, 3}, {10, 255, 255, 255}, {11, 0, 0, 0},
+		{172, 16, 255, 1}, {172, 17, 0, 0},
+		{192, 168, 0, 1}, {192, 168, 0, 200}, {192, 168, 1, 255}, {192, 168, 2, 0},
+		{8, 8, 8, 8}, {8, 8, 8, 9},
+		net.ParseIP("2001:db8:ffff::1"), net.ParseIP("2001:db9::1"),
+	}
+	for _, ip := range probes {
+		assert(contains(collapsed, ip), Equals, contains(cidrs, ip))
+	}
+}
+
+func BenchmarkNonMatchingRule(b *testing.B) {
+	domain := &Domain{
+		Type:  Domain_Regex,
+		Value: "^(www\\.)?v2ray\\.(com|org)$",
+	}
+	rule := &RoutingRule{
+		Domain:     []*Domain{domain},
+		InboundTag: []string{"in"},
+	}
+	sorted, err := rule.BuildCondition()
+	common.Must(err)
+
+	// The same conditions in the order they are declared, as if they were not sorted by cost.
+	domainMatcher := NewCachableDomainMatcher()
+	common.Must(domainMatcher.Add(domain))
+	unsorted := NewConditionChan().Add(domainMatcher).Add(NewInboundTagMatcher(rule.InboundTag))
+
+	ctxs := make([]context.Context, 1024)
+	for i := range ctxs {
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(strconv.Itoa(i)+".v2ray.com"), 80))
+		ctxs[i] = proxy.ContextWithInboundTag(ctx, "other")
+	}
+
+	for _, bench := range []struct {
+		name string
+		cond Condition
+	}{
+		{"Sorted", sorted},
+		{"Unsorted", unsorted},
+	} {
+		cond := bench.cond
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cond.Apply(ctxs[i%len(ctxs)])
+			}
+		})
+	}
+}
+
+type staticResolver []net.Address
+
+func (r staticResolver) Resolve() []net.Address {
+	return r
+}
+
+func TestSameSubnetRule(t *testing.T) {
+	assert := With(t)
+
+	cond, err := (&RoutingRule{
+		SameSubnet: &SameSubnet{},
+	}).BuildCondition()
+	assert(err, IsNil)
+
+	ctx := func(src string, dest net.Address) context.Context {
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress(src), 1234))
+		return proxy.ContextWithTarget(ctx, net.TCPDestination(dest, 80))
+	}
+
+	assert(cond.Apply(ctx("192.168.1.10", net.ParseAddress("192.168.1.200"))), IsTrue)
+	assert(cond.Apply(ctx("192.168.1.10", net.ParseAddress("192.168.2.1"))), IsFalse)
+	assert(cond.Apply(ctx("2001:db8::1", net.ParseAddress("2001:db8::ffff:1"))), IsTrue)
+	assert(cond.Apply(ctx("2001:db8::1", net.ParseAddress("2001:db8:0:1::1"))), IsFalse)
+	assert(cond.Apply(ctx("192.168.1.10", net.ParseAddress("2001:db8::1"))), IsFalse)
+
+	// Domains only match through resolved IPs.
+	domain := ctx("192.168.1.10", net.DomainAddress("nas.lan"))
+	assert(cond.Apply(domain), IsFalse)
+	assert(cond.Apply(proxy.ContextWithResolveIPs(domain, staticResolver{net.ParseAddress("192.168.1.2")})), IsTrue)
+
+	cond, err = (&RoutingRule{
+		SameSubnet: &SameSubnet{
+			Ipv4Prefix: 16,
+		},
+	}).BuildCondition()
+	assert(err, IsNil)
+	assert(cond.Apply(ctx("192.168.1.10", net.ParseAddress("192.168.2.1"))), IsTrue)
+
+	_, err = (&RoutingRule{
+		SameSubnet: &SameSubnet{
+			Ipv4Prefix: 33,
+		},
+	}).BuildCondition()
+	assert(err, IsNotNil)
+}
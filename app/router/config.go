@@ -1,26 +1,319 @@
 package router
 
 import (
+	"bytes"
 	"context"
+	"sort"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"v2ray.com/core/common/net"
+	"v2ray.com/core/common/serial"
+	"v2ray.com/core/proxy"
 )
 
 type Rule struct {
 	Tag       string
 	Condition Condition
+
+	// Individual conditions of the rule, for evaluating them in isolation.
+	domain   Condition
+	ip       Condition
+	port     Condition
+	network  Condition
+	sourceIP Condition
+
+	domainStrategy Config_DomainStrategy
+
+	fragmentClientHello bool
 }
 
 func (r *Rule) Apply(ctx context.Context) bool {
 	return r.Condition.Apply(ctx)
 }
 
+func matchTarget(cond Condition, dest net.Destination) bool {
+	if cond == nil {
+		return false
+	}
+	return cond.Apply(proxy.ContextWithTarget(context.Background(), dest))
+}
+
+// MatchDomain returns true if the domain matches the domain conditions of the rule.
+// All Match methods return false if the rule doesn't have the corresponding condition.
+func (r *Rule) MatchDomain(domain string) bool {
+	return matchTarget(r.domain, net.TCPDestination(net.DomainAddress(domain), 0))
+}
+
+// MatchIP returns true if the IP matches the destination CIDRs of the rule.
+func (r *Rule) MatchIP(ip net.IP) bool {
+	return matchTarget(r.ip, net.TCPDestination(net.IPAddress(ip), 0))
+}
+
+// MatchPort returns true if the port matches the destination ports of the rule.
+func (r *Rule) MatchPort(port net.Port) bool {
+	return matchTarget(r.port, net.TCPDestination(net.AnyIP, port))
+}
+
+// MatchNetwork returns true if the network matches the network list of the rule.
+func (r *Rule) MatchNetwork(network net.Network) bool {
+	return matchTarget(r.network, net.Destination{Network: network, Address: net.AnyIP})
+}
+
+// MatchSourceIP returns true if the IP matches the source CIDRs of the rule.
+func (r *Rule) MatchSourceIP(ip net.IP) bool {
+	if r.sourceIP == nil {
+		return false
+	}
+	return r.sourceIP.Apply(proxy.ContextWithSource(context.Background(), net.TCPDestination(net.IPAddress(ip), 0)))
+}
+
+// MatchEndpoints returns true if the source and destination IPs match the source and destination
+// CIDRs of the rule, both at the same time. A side the rule has no CIDRs for is not checked,
+// but at least one side must be present in the rule.
+func (r *Rule) MatchEndpoints(source net.IP, target net.IP) bool {
+	if r.ip == nil && r.sourceIP == nil {
+		return false
+	}
+	return (r.sourceIP == nil || r.MatchSourceIP(source)) && (r.ip == nil || r.MatchIP(target))
+}
+
+// InvalidCIDRError is returned when a routing rule contains a CIDR that can't be used.
+type InvalidCIDRError struct {
+	RuleIndex int
+	IP        []byte
+	Prefix    uint32
+	Reason    string
+}
+
+func (e *InvalidCIDRError) Error() string {
+	return serial.Concat("invalid CIDR in rule ", e.RuleIndex, ": ", net.IP(e.IP), "/", e.Prefix, ": ", e.Reason)
+}
+
+// BadRegexError is returned when a regex domain in a routing rule doesn't compile.
+type BadRegexError struct {
+	RuleIndex int
+	Pattern   string
+	Err       error
+}
+
+func (e *BadRegexError) Error() string {
+	return serial.Concat("bad regex in rule ", e.RuleIndex, ": ", e.Pattern, ": ", e.Err)
+}
+
+// EmptyRuleError is returned when a routing rule has no effective condition.
+type EmptyRuleError struct {
+	RuleIndex int
+}
+
+func (e *EmptyRuleError) Error() string {
+	return serial.Concat("rule ", e.RuleIndex, " has no effective fields")
+}
+
+// BroadRuleError reports a rule that matches everything from its inbounds,
+// without being marked as intentional.
+type BroadRuleError struct {
+	RuleIndex int
+	Tag       string
+}
+
+func (e *BroadRuleError) Error() string {
+	return serial.Concat("rule ", e.RuleIndex, " sends all traffic from its inbounds to [", e.Tag, "], set intentional if this is intended")
+}
+
+// LimitExceededError is returned when a routing config is larger than its configured limits.
+type LimitExceededError struct {
+	// Limit is the name of the exceeded limit, such as "max_rules".
+	Limit  string
+	Max    uint32
+	Actual int
+}
+
+func (e *LimitExceededError) Error() string {
+	return serial.Concat("routing config exceeds ", e.Limit, ": ", e.Actual, " > ", e.Max)
+}
+
+// checkLimits ensures the config stays within its size limits, before compiling any rule.
+func (c *Config) checkLimits() error {
+	var domains, cidrs int
+	for _, rule := range c.Rule {
+		domains += len(rule.Domain)
+		cidrs += len(rule.Cidr) + len(rule.SourceCidr)
+		// Sets are compiled into each rule that references them.
+		for _, name := range rule.CidrSet {
+			cidrs += len(c.CidrSets[name].GetCidr())
+		}
+	}
+	cidrs += len(c.TrustedSource)
+
+	limits := []struct {
+		name   string
+		max    uint32
+		actual int
+	}{
+		{"max_rules", c.MaxRules, len(c.Rule)},
+		{"max_domains", c.MaxDomains, domains},
+		{"max_cidrs", c.MaxCidrs, cidrs},
+	}
+	for _, l := range limits {
+		if l.max > 0 && l.actual > int(l.max) {
+			return &LimitExceededError{Limit: l.name, Max: l.max, Actual: l.actual}
+		}
+	}
+	return nil
+}
+
+// Lint reports rules that are valid but likely mistakes. It doesn't stop the router from loading.
+func (c *Config) Lint() []error {
+	var errs []error
+	for idx, rule := range c.Rule {
+		if !rule.Intentional && rule.isInboundOnly() {
+			errs = append(errs, &BroadRuleError{RuleIndex: idx, Tag: rule.Tag})
+		}
+	}
+	return errs
+}
+
+// ReachableOutbounds returns the sorted tags of all outbounds that routing may select,
+// including the default, DNS and trusted source outbound tags.
+func (c *Config) ReachableOutbounds() []string {
+	seen := make(map[string]bool)
+	var tags []string
+	add := func(tag string) {
+		if len(tag) > 0 && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	for _, rule := range c.Rule {
+		add(rule.Tag)
+	}
+	add(c.DefaultOutboundTag)
+	add(c.DnsOutboundTag)
+	add(c.TrustedOutboundTag)
+	sort.Strings(tags)
+	return tags
+}
+
+// portBuckets are the port ranges assigned by IANA.
+var portBuckets = map[string]net.PortRange{
+	"well-known": {From: 0, To: 1023},
+	"registered": {From: 1024, To: 49151},
+	"dynamic":    {From: 49152, To: 65535},
+}
+
+// isInboundOnly returns true if inbound_tag is the only condition of the rule.
+func (rr *RoutingRule) isInboundOnly() bool {
+	if len(rr.InboundTag) == 0 {
+		return false
+	}
+	r := *rr
+	r.Tag = ""
+	r.InboundTag = nil
+	r.Intentional = false
+	return proto.Equal(&r, &RoutingRule{})
+}
+
+// withRuleIndex sets the index of the failing rule into a config loading error.
+func withRuleIndex(err error, idx int) error {
+	switch e := err.(type) {
+	case *InvalidCIDRError:
+		e.RuleIndex = idx
+	case *BadRegexError:
+		e.RuleIndex = idx
+	case *EmptyRuleError:
+		e.RuleIndex = idx
+	}
+	return err
+}
+
+// CollapseCIDRs returns the smallest list of CIDRs that covers the same addresses as the given ones.
+// CIDRs covered by another one are removed, and adjacent CIDRs are merged. The result is sorted,
+// with IPv4 before IPv6. CIDRs with invalid IP length or prefix are dropped.
+func CollapseCIDRs(cidrs []*CIDR) []*CIDR {
+	var v4, v6 []*CIDR
+	for _, c := range cidrs {
+		if c.Prefix > uint32(len(c.Ip)*8) {
+			continue
+		}
+		masked := &CIDR{
+			Ip:     maskIP(c.Ip, c.Prefix),
+			Prefix: c.Prefix,
+		}
+		switch len(c.Ip) {
+		case net.IPv4len:
+			v4 = append(v4, masked)
+		case net.IPv6len:
+			v6 = append(v6, masked)
+		}
+	}
+	return append(collapseFamily(v4), collapseFamily(v6)...)
+}
+
+func collapseFamily(cidrs []*CIDR) []*CIDR {
+	sort.Slice(cidrs, func(i, j int) bool {
+		if c := bytes.Compare(cidrs[i].Ip, cidrs[j].Ip); c != 0 {
+			return c < 0
+		}
+		return cidrs[i].Prefix < cidrs[j].Prefix
+	})
+
+	// After sorting, a CIDR can only be covered by the last one kept, and the CIDRs kept are
+	// disjoint and in order, so merging only needs to look at the top of the stack.
+	var stack []*CIDR
+	for _, c := range cidrs {
+		if n := len(stack); n > 0 {
+			last := stack[n-1]
+			if last.Prefix <= c.Prefix && bytes.Equal(maskIP(c.Ip, last.Prefix), last.Ip) {
+				continue
+			}
+		}
+		stack = append(stack, c)
+		for len(stack) >= 2 {
+			a, b := stack[len(stack)-2], stack[len(stack)-1]
+			if a.Prefix != b.Prefix || a.Prefix == 0 {
+				break
+			}
+			parent := maskIP(a.Ip, a.Prefix-1)
+			if !bytes.Equal(parent, a.Ip) || !bytes.Equal(maskIP(b.Ip, a.Prefix-1), parent) {
+				break
+			}
+			stack = append(stack[:len(stack)-2], &CIDR{Ip: parent, Prefix: a.Prefix - 1})
+		}
+	}
+	return stack
+}
+
+// maskIP returns a copy of ip with all bits after the prefix cleared.
+func maskIP(ip []byte, prefix uint32) []byte {
+	masked := make([]byte, len(ip))
+	for i := range ip {
+		bits := int(prefix) - i*8
+		switch {
+		case bits >= 8:
+			masked[i] = ip[i]
+		case bits > 0:
+			masked[i] = ip[i] & (0xff << uint(8-bits))
+		}
+	}
+	return masked
+}
+
 func cidrToCondition(cidr []*CIDR, source bool) (Condition, error) {
 	ipv4Net := net.NewIPNetTable()
 	ipv6Cond := NewAnyCondition()
 	hasIpv6 := false
 
 	for _, ip := range cidr {
+		if len(ip.Ip) != net.IPv4len && len(ip.Ip) != net.IPv6len {
+			return nil, &InvalidCIDRError{IP: ip.Ip, Prefix: ip.Prefix, Reason: "invalid IP length"}
+		}
+		if ip.Prefix > uint32(len(ip.Ip)*8) {
+			return nil, &InvalidCIDRError{IP: ip.Ip, Prefix: ip.Prefix, Reason: "prefix too long"}
+		}
+	}
+
+	for _, ip := range CollapseCIDRs(cidr) {
 		switch len(ip.Ip) {
 		case net.IPv4len:
 			ipv4Net.AddIP(ip.Ip, byte(ip.Prefix))
@@ -31,8 +324,6 @@ func cidrToCondition(cidr []*CIDR, source bool) (Condition, error) {
 				return nil, err
 			}
 			ipv6Cond.Add(matcher)
-		default:
-			return nil, newError("invalid IP length").AtWarning()
 		}
 	}
 
@@ -49,30 +340,99 @@ func cidrToCondition(cidr []*CIDR, source bool) (Condition, error) {
 }
 
 func (rr *RoutingRule) BuildCondition() (Condition, error) {
+	rule, err := rr.Build()
+	if err != nil {
+		return nil, err
+	}
+	return rule.Condition, nil
+}
+
+// Build compiles the routing rule, so that its conditions can be evaluated together or one by one.
+func (rr *RoutingRule) Build() (*Rule, error) {
+	return rr.build(nil)
+}
+
+func (rr *RoutingRule) build(config *Config) (*Rule, error) {
+	rule := &Rule{
+		Tag:                 rr.Tag,
+		fragmentClientHello: rr.FragmentClientHello,
+	}
+	if rr.DomainStrategy != nil {
+		rule.domainStrategy = rr.DomainStrategy.DomainStrategy
+		if rule.domainStrategy == Config_UseIp {
+			rule.domainStrategy = Config_IpOnDemand
+		}
+	} else {
+		rule.domainStrategy = config.GetDomainStrategy()
+	}
 	conds := NewConditionChan()
 
 	if len(rr.Domain) > 0 {
 		matcher := NewCachableDomainMatcher()
+		matcher.unanchoredRegex = config.GetUnanchoredRegex()
 		for _, domain := range rr.Domain {
-			matcher.Add(domain)
+			if err := matcher.Add(domain); err != nil {
+				return nil, err
+			}
 		}
+		rule.domain = matcher
 		conds.Add(matcher)
 	}
 
-	if len(rr.Cidr) > 0 {
-		cond, err := cidrToCondition(rr.Cidr, false)
+	cidrs := rr.Cidr
+	if len(rr.CidrSet) > 0 {
+		cidrs = append([]*CIDR(nil), rr.Cidr...)
+		for _, name := range rr.CidrSet {
+			set, found := config.GetCidrSets()[name]
+			if !found {
+				return nil, newError("unknown CIDR set: ", name).AtWarning()
+			}
+			cidrs = append(cidrs, set.Cidr...)
+		}
+	}
+	if len(cidrs) > 0 {
+		cond, err := cidrToCondition(cidrs, false)
 		if err != nil {
 			return nil, err
 		}
+		rule.ip = cond
 		conds.Add(cond)
 	}
 
-	if rr.PortRange != nil {
-		conds.Add(NewPortMatcher(*rr.PortRange))
+	if len(rr.PortProfile) > 0 || len(rr.PortBucket) > 0 {
+		var ranges []net.PortRange
+		if rr.PortRange != nil {
+			ranges = append(ranges, *rr.PortRange)
+		}
+		for _, name := range rr.PortProfile {
+			profile, found := config.GetPortProfiles()[name]
+			if !found {
+				return nil, newError("unknown port profile: ", name).AtWarning()
+			}
+			for _, pr := range profile.Range {
+				ranges = append(ranges, *pr)
+			}
+		}
+		for _, name := range rr.PortBucket {
+			bucket, found := portBuckets[name]
+			if !found {
+				return nil, newError("unknown port bucket: ", name).AtWarning()
+			}
+			ranges = append(ranges, bucket)
+		}
+		rule.port = NewPortListMatcher(net.MergePortRanges(ranges))
+	} else if rr.PortRange != nil {
+		rule.port = NewPortMatcher(*rr.PortRange)
+	}
+	if rule.port != nil {
+		conds.Add(rule.port)
 	}
 
 	if rr.NetworkList != nil {
-		conds.Add(NewNetworkMatcher(rr.NetworkList))
+		rule.network = NewNetworkMatcher(&net.NetworkList{
+			Network: net.MergeNetworks(rr.NetworkList.Network),
+		})
+		conds.Add(rule.network)
 	}
 
 	if len(rr.SourceCidr) > 0 {
@@ -80,6 +440,7 @@ func (rr *RoutingRule) BuildCondition() (Condition, error) {
 		if err != nil {
 			return nil, err
 		}
+		rule.sourceIP = cond
 		conds.Add(cond)
 	}
 
@@ -91,9 +452,131 @@ func (rr *RoutingRule) BuildCondition() (Condition, error) {
 		conds.Add(NewInboundTagMatcher(rr.InboundTag))
 	}
 
+	if len(rr.InboundTransport) > 0 {
+		matcher, err := NewInboundTransportMatcher(rr.InboundTransport)
+		if err != nil {
+			return nil, err
+		}
+		conds.Add(matcher)
+	}
+
+	if len(rr.SourceUser) > 0 {
+		conds.Add(NewSourceUserMatcher(rr.SourceUser))
+	}
+
+	if len(rr.HttpMethod) > 0 {
+		conds.Add(NewHTTPMethodMatcher(rr.HttpMethod))
+	}
+
+	if len(rr.TlsVersion) > 0 {
+		matcher, err := NewTLSVersionMatcher(rr.TlsVersion)
+		if err != nil {
+			return nil, err
+		}
+		conds.Add(matcher)
+	}
+
+	if len(rr.HttpHeader) > 0 {
+		conds.Add(NewHTTPHeaderMatcher(rr.HttpHeader))
+	}
+
+	if len(rr.Ja3) > 0 {
+		conds.Add(NewJA3Matcher(rr.Ja3))
+	}
+
+	switch rr.SniffResult {
+	case RoutingRule_Sniffed:
+		conds.Add(NewSniffResultMatcher(true))
+	case RoutingRule_Unsniffed:
+		conds.Add(NewSniffResultMatcher(false))
+	}
+
+	if len(rr.NotProtocol) > 0 {
+		conds.Add(NewNotProtocolMatcher(rr.NotProtocol))
+	}
+
+	if rr.MinSourceConnections > 0 {
+		conds.Add(NewSourceConnectionsMatcher(rr.MinSourceConnections))
+	}
+
+	if rr.ResolutionFailed {
+		conds.Add(NewResolutionFailedMatcher())
+	}
+
+	if rr.HostnameEntropy != nil {
+		if rr.HostnameEntropy.Threshold <= 0 {
+			return nil, newError("invalid hostname entropy threshold: ", rr.HostnameEntropy.Threshold).AtWarning()
+		}
+		minLength := rr.HostnameEntropy.MinLength
+		if minLength == 0 {
+			minLength = 8
+		}
+		conds.Add(NewHostnameEntropyMatcher(rr.HostnameEntropy.Threshold, minLength))
+	}
+
+	if rr.DirectProbe != nil {
+		timeout := time.Duration(rr.DirectProbe.Timeout) * time.Millisecond
+		if timeout == 0 {
+			timeout = time.Millisecond * 300
+		}
+		ttl := time.Duration(rr.DirectProbe.CacheTtl) * time.Second
+		if ttl == 0 {
+			ttl = time.Minute * 5
+		}
+		conds.Add(NewDirectProbeMatcher(timeout, ttl))
+	}
+
+	if rr.SameSubnet != nil {
+		ipv4Prefix, ipv6Prefix := rr.SameSubnet.Ipv4Prefix, rr.SameSubnet.Ipv6Prefix
+		if ipv4Prefix == 0 {
+			ipv4Prefix = 24
+		}
+		if ipv6Prefix == 0 {
+			ipv6Prefix = 64
+		}
+		if ipv4Prefix > 32 || ipv6Prefix > 128 {
+			return nil, newError("invalid subnet prefix: ", ipv4Prefix, ", ", ipv6Prefix).AtWarning()
+		}
+		conds.Add(NewSameSubnetMatcher(ipv4Prefix, ipv6Prefix))
+	}
+
+	if rr.MaxOutboundConnections > 0 {
+		conds.Add(NewOutboundCapacityMatcher(rr.Tag, rr.MaxOutboundConnections))
+	}
+
+	if rr.Split != nil {
+		if rr.Split.Percent > 100 {
+			return nil, newError("split percent must not exceed 100: ", rr.Split.Percent).AtWarning()
+		}
+		conds.Add(NewSplitMatcher(rr.Split.Key, rr.Split.Percent))
+	}
+
+	if rr.FirstSeen != nil {
+		window := time.Duration(rr.FirstSeen.Window) * time.Second
+		if window == 0 {
+			window = time.Hour
+		}
+		capacity := rr.FirstSeen.Capacity
+		if capacity == 0 {
+			capacity = 65536
+		}
+		conds.Add(NewFirstSeenMatcher(window, capacity))
+	}
+
+	// Rate limit is in the costSideEffect tier, so that sortByCost puts it after all conditions
+	// without side effects. Only connections matching all conditions take a token.
+	if rr.RateLimit != nil {
+		if rr.RateLimit.Rate == 0 {
+			return nil, newError("rate limit must be positive").AtWarning()
+		}
+		conds.Add(NewRateLimitMatcher(rr.RateLimit.Rate, rr.RateLimit.Burst))
+	}
+
 	if conds.Len() == 0 {
-		return nil, newError("this rule has no effective fields").AtWarning()
+		return nil, &EmptyRuleError{}
 	}
 
-	return conds, nil
+	conds.sortByCost()
+	rule.Condition = conds
+	return rule, nil
 }
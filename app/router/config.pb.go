@@ -27,17 +27,23 @@ const (
 	Domain_Regex Domain_Type = 1
 	// The value is a domain.
 	Domain_Domain Domain_Type = 2
+	// The value is a domain, and only its subdomains match, not the domain
+	// itself. A leading "*." in the value is ignored. A Domain value with a
+	// leading "*." is treated as Wildcard.
+	Domain_Wildcard Domain_Type = 3
 )
 
 var Domain_Type_name = map[int32]string{
 	0: "Plain",
 	1: "Regex",
 	2: "Domain",
+	3: "Wildcard",
 }
 var Domain_Type_value = map[string]int32{
-	"Plain":  0,
-	"Regex":  1,
-	"Domain": 2,
+	"Plain":    0,
+	"Regex":    1,
+	"Domain":   2,
+	"Wildcard": 3,
 }
 
 func (x Domain_Type) String() string {
@@ -45,6 +51,61 @@ func (x Domain_Type) String() string {
 }
 func (Domain_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0, 0} }
 
+type Split_Key int32
+
+const (
+	// IP address of the source.
+	Split_SourceIP Split_Key = 0
+	// IP address and port of the source.
+	Split_Source Split_Key = 1
+	// Destination address and port.
+	Split_Target Split_Key = 2
+)
+
+var Split_Key_name = map[int32]string{
+	0: "SourceIP",
+	1: "Source",
+	2: "Target",
+}
+var Split_Key_value = map[string]int32{
+	"SourceIP": 0,
+	"Source":   1,
+	"Target":   2,
+}
+
+func (x Split_Key) String() string {
+	return proto.EnumName(Split_Key_name, int32(x))
+}
+func (Split_Key) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{15, 0} }
+
+type RoutingRule_SniffResult int32
+
+const (
+	// Matches regardless of sniffing.
+	RoutingRule_Any RoutingRule_SniffResult = 0
+	// Matches connections whose protocol and domain were sniffed.
+	RoutingRule_Sniffed RoutingRule_SniffResult = 1
+	// Matches connections that were not sniffed, either because sniffing
+	// failed or was not attempted.
+	RoutingRule_Unsniffed RoutingRule_SniffResult = 2
+)
+
+var RoutingRule_SniffResult_name = map[int32]string{
+	0: "Any",
+	1: "Sniffed",
+	2: "Unsniffed",
+}
+var RoutingRule_SniffResult_value = map[string]int32{
+	"Any":       0,
+	"Sniffed":   1,
+	"Unsniffed": 2,
+}
+
+func (x RoutingRule_SniffResult) String() string {
+	return proto.EnumName(RoutingRule_SniffResult_name, int32(x))
+}
+func (RoutingRule_SniffResult) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{16, 0} }
+
 type Config_DomainStrategy int32
 
 const (
@@ -74,7 +135,7 @@ var Config_DomainStrategy_value = map[string]int32{
 func (x Config_DomainStrategy) String() string {
 	return proto.EnumName(Config_DomainStrategy_name, int32(x))
 }
-func (Config_DomainStrategy) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{7, 0} }
+func (Config_DomainStrategy) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{17, 0} }
 
 // Domain for routing decision.
 type Domain struct {
@@ -82,6 +143,14 @@ type Domain struct {
 	Type Domain_Type `protobuf:"varint,1,opt,name=type,enum=v2ray.core.app.router.Domain_Type" json:"type,omitempty"`
 	// Domain value.
 	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	// By default, a regex value that neither starts with "^" nor ends with "$"
+	// must match the whole domain. If set, it may match any part of the
+	// domain instead.
+	Unanchored bool `protobuf:"varint,3,opt,name=unanchored" json:"unanchored,omitempty"`
+	// For Domain and Wildcard types, the minimum number of labels a domain must have to
+	// match. For example, "cn" with min_labels 3 matches "www.example.cn" but
+	// neither "example.cn" nor "cn".
+	MinLabels uint32 `protobuf:"varint,4,opt,name=min_labels,json=minLabels" json:"min_labels,omitempty"`
 }
 
 func (m *Domain) Reset()                    { *m = Domain{} }
@@ -103,6 +172,20 @@ func (m *Domain) GetValue() string {
 	return ""
 }
 
+func (m *Domain) GetUnanchored() bool {
+	if m != nil {
+		return m.Unanchored
+	}
+	return false
+}
+
+func (m *Domain) GetMinLabels() uint32 {
+	if m != nil {
+		return m.MinLabels
+	}
+	return 0
+}
+
 // IP for routing decision, in CIDR form.
 type CIDR struct {
 	// IP address, should be either 4 or 16 bytes.
@@ -210,21 +293,333 @@ func (m *GeoSiteList) GetEntry() []*GeoSite {
 	return nil
 }
 
+// RateLimit limits the number of new connections per second.
+type RateLimit struct {
+	// Number of new connections allowed per second.
+	Rate uint32 `protobuf:"varint,1,opt,name=rate" json:"rate,omitempty"`
+	// Maximum number of connections allowed in a burst. Same as rate if unset.
+	Burst uint32 `protobuf:"varint,2,opt,name=burst" json:"burst,omitempty"`
+}
+
+func (m *RateLimit) Reset()                    { *m = RateLimit{} }
+func (m *RateLimit) String() string            { return proto.CompactTextString(m) }
+func (*RateLimit) ProtoMessage()               {}
+func (*RateLimit) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *RateLimit) GetRate() uint32 {
+	if m != nil {
+		return m.Rate
+	}
+	return 0
+}
+
+func (m *RateLimit) GetBurst() uint32 {
+	if m != nil {
+		return m.Burst
+	}
+	return 0
+}
+
+// FirstSeen matches sources not seen by the rule within a window.
+type FirstSeen struct {
+	// Window in seconds. Defaults to 3600.
+	Window uint32 `protobuf:"varint,1,opt,name=window" json:"window,omitempty"`
+	// Maximum number of sources remembered. The least recently seen sources
+	// are forgotten first. Defaults to 65536.
+	Capacity uint32 `protobuf:"varint,2,opt,name=capacity" json:"capacity,omitempty"`
+}
+
+func (m *FirstSeen) Reset()                    { *m = FirstSeen{} }
+func (m *FirstSeen) String() string            { return proto.CompactTextString(m) }
+func (*FirstSeen) ProtoMessage()               {}
+func (*FirstSeen) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *FirstSeen) GetWindow() uint32 {
+	if m != nil {
+		return m.Window
+	}
+	return 0
+}
+
+func (m *FirstSeen) GetCapacity() uint32 {
+	if m != nil {
+		return m.Capacity
+	}
+	return 0
+}
+
+// CIDRList is a named group of IP ranges.
+type CIDRList struct {
+	Cidr []*CIDR `protobuf:"bytes,1,rep,name=cidr" json:"cidr,omitempty"`
+}
+
+func (m *CIDRList) Reset()                    { *m = CIDRList{} }
+func (m *CIDRList) String() string            { return proto.CompactTextString(m) }
+func (*CIDRList) ProtoMessage()               {}
+func (*CIDRList) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *CIDRList) GetCidr() []*CIDR {
+	if m != nil {
+		return m.Cidr
+	}
+	return nil
+}
+
+// PortList is a named group of port ranges.
+type PortList struct {
+	Range []*v2ray_core_common_net.PortRange `protobuf:"bytes,1,rep,name=range" json:"range,omitempty"`
+}
+
+func (m *PortList) Reset()                    { *m = PortList{} }
+func (m *PortList) String() string            { return proto.CompactTextString(m) }
+func (*PortList) ProtoMessage()               {}
+func (*PortList) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *PortList) GetRange() []*v2ray_core_common_net.PortRange {
+	if m != nil {
+		return m.Range
+	}
+	return nil
+}
+
+// HTTPHeader matches a header of sniffed HTTP requests.
+type HTTPHeader struct {
+	// Name of the header, case-insensitive.
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Exact value of the header. If empty, the header only needs to be present.
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *HTTPHeader) Reset()                    { *m = HTTPHeader{} }
+func (m *HTTPHeader) String() string            { return proto.CompactTextString(m) }
+func (*HTTPHeader) ProtoMessage()               {}
+func (*HTTPHeader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *HTTPHeader) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *HTTPHeader) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+// SameSubnet matches connections whose destination IP is in the same subnet
+// as the source IP.
+type SameSubnet struct {
+	// Prefix length of IPv4 subnets. Defaults to 24.
+	Ipv4Prefix uint32 `protobuf:"varint,1,opt,name=ipv4_prefix,json=ipv4Prefix" json:"ipv4_prefix,omitempty"`
+	// Prefix length of IPv6 subnets. Defaults to 64.
+	Ipv6Prefix uint32 `protobuf:"varint,2,opt,name=ipv6_prefix,json=ipv6Prefix" json:"ipv6_prefix,omitempty"`
+}
+
+func (m *SameSubnet) Reset()                    { *m = SameSubnet{} }
+func (m *SameSubnet) String() string            { return proto.CompactTextString(m) }
+func (*SameSubnet) ProtoMessage()               {}
+func (*SameSubnet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *SameSubnet) GetIpv4Prefix() uint32 {
+	if m != nil {
+		return m.Ipv4Prefix
+	}
+	return 0
+}
+
+func (m *SameSubnet) GetIpv6Prefix() uint32 {
+	if m != nil {
+		return m.Ipv6Prefix
+	}
+	return 0
+}
+
+// HostnameEntropy matches domain destinations with a random looking label,
+// as generated by domain generation algorithms.
+type HostnameEntropy struct {
+	// Shannon entropy, in bits per character, a label must exceed.
+	Threshold float64 `protobuf:"fixed64,1,opt,name=threshold" json:"threshold,omitempty"`
+	// Labels shorter than this are ignored. Defaults to 8.
+	MinLength uint32 `protobuf:"varint,2,opt,name=min_length,json=minLength" json:"min_length,omitempty"`
+}
+
+func (m *HostnameEntropy) Reset()                    { *m = HostnameEntropy{} }
+func (m *HostnameEntropy) String() string            { return proto.CompactTextString(m) }
+func (*HostnameEntropy) ProtoMessage()               {}
+func (*HostnameEntropy) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *HostnameEntropy) GetThreshold() float64 {
+	if m != nil {
+		return m.Threshold
+	}
+	return 0
+}
+
+func (m *HostnameEntropy) GetMinLength() uint32 {
+	if m != nil {
+		return m.MinLength
+	}
+	return 0
+}
+
+// DirectProbe matches TCP destinations that accept a direct connection from
+// this host. Results are cached per destination. Domain destinations are
+// probed at the first IP resolved by the router, so they only match if the
+// domain strategy of the rule resolves domains. The system resolver is never
+// used. Probes end early if the connection being routed ends.
+type DirectProbe struct {
+	// Time to wait for the connection, in milliseconds. Defaults to 300.
+	Timeout uint32 `protobuf:"varint,1,opt,name=timeout" json:"timeout,omitempty"`
+	// How long a result is cached, in seconds. Defaults to 300.
+	CacheTtl uint32 `protobuf:"varint,2,opt,name=cache_ttl,json=cacheTtl" json:"cache_ttl,omitempty"`
+}
+
+func (m *DirectProbe) Reset()                    { *m = DirectProbe{} }
+func (m *DirectProbe) String() string            { return proto.CompactTextString(m) }
+func (*DirectProbe) ProtoMessage()               {}
+func (*DirectProbe) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *DirectProbe) GetTimeout() uint32 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *DirectProbe) GetCacheTtl() uint32 {
+	if m != nil {
+		return m.CacheTtl
+	}
+	return 0
+}
+
+// DomainStrategyOverride sets the domain strategy of a single rule.
+type DomainStrategyOverride struct {
+	// UseIp resolves domains for the rule, same as IpOnDemand. In Config, UseIp
+	// doesn't resolve domains.
+	DomainStrategy Config_DomainStrategy `protobuf:"varint,1,opt,name=domain_strategy,json=domainStrategy,enum=v2ray.core.app.router.Config_DomainStrategy" json:"domain_strategy,omitempty"`
+}
+
+func (m *DomainStrategyOverride) Reset()                    { *m = DomainStrategyOverride{} }
+func (m *DomainStrategyOverride) String() string            { return proto.CompactTextString(m) }
+func (*DomainStrategyOverride) ProtoMessage()               {}
+func (*DomainStrategyOverride) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *DomainStrategyOverride) GetDomainStrategy() Config_DomainStrategy {
+	if m != nil {
+		return m.DomainStrategy
+	}
+	return Config_AsIs
+}
+
+// Split matches a stable share of connections, chosen by hashing a key.
+type Split struct {
+	Key Split_Key `protobuf:"varint,1,opt,name=key,enum=v2ray.core.app.router.Split_Key" json:"key,omitempty"`
+	// Percentage of keys to match, from 0 to 100.
+	Percent uint32 `protobuf:"varint,2,opt,name=percent" json:"percent,omitempty"`
+}
+
+func (m *Split) Reset()                    { *m = Split{} }
+func (m *Split) String() string            { return proto.CompactTextString(m) }
+func (*Split) ProtoMessage()               {}
+func (*Split) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *Split) GetKey() Split_Key {
+	if m != nil {
+		return m.Key
+	}
+	return Split_SourceIP
+}
+
+func (m *Split) GetPercent() uint32 {
+	if m != nil {
+		return m.Percent
+	}
+	return 0
+}
+
 type RoutingRule struct {
-	Tag         string                              `protobuf:"bytes,1,opt,name=tag" json:"tag,omitempty"`
-	Domain      []*Domain                           `protobuf:"bytes,2,rep,name=domain" json:"domain,omitempty"`
+	Tag    string    `protobuf:"bytes,1,opt,name=tag" json:"tag,omitempty"`
+	Domain []*Domain `protobuf:"bytes,2,rep,name=domain" json:"domain,omitempty"`
+	// IP ranges of the destination. If source_cidr is also set, both the
+	// source and the destination must match.
 	Cidr        []*CIDR                             `protobuf:"bytes,3,rep,name=cidr" json:"cidr,omitempty"`
 	PortRange   *v2ray_core_common_net.PortRange    `protobuf:"bytes,4,opt,name=port_range,json=portRange" json:"port_range,omitempty"`
 	NetworkList *v2ray_core_common_net1.NetworkList `protobuf:"bytes,5,opt,name=network_list,json=networkList" json:"network_list,omitempty"`
 	SourceCidr  []*CIDR                             `protobuf:"bytes,6,rep,name=source_cidr,json=sourceCidr" json:"source_cidr,omitempty"`
 	UserEmail   []string                            `protobuf:"bytes,7,rep,name=user_email,json=userEmail" json:"user_email,omitempty"`
 	InboundTag  []string                            `protobuf:"bytes,8,rep,name=inbound_tag,json=inboundTag" json:"inbound_tag,omitempty"`
+	// Matches domain destinations that failed to resolve to any IP. Only
+	// effective when the domain strategy resolves domains.
+	ResolutionFailed bool `protobuf:"varint,9,opt,name=resolution_failed,json=resolutionFailed" json:"resolution_failed,omitempty"`
+	// Excludes connections whose sniffed protocol is in the list. Connections
+	// that were not sniffed are not excluded.
+	NotProtocol []string `protobuf:"bytes,10,rep,name=not_protocol,json=notProtocol" json:"not_protocol,omitempty"`
+	// Limits how many new connections this rule matches. Connections over the
+	// limit are treated as not matching this rule.
+	RateLimit *RateLimit `protobuf:"bytes,11,opt,name=rate_limit,json=rateLimit" json:"rate_limit,omitempty"`
+	// Usernames that clients authenticated to the inbound with, e.g. on SOCKS
+	// or HTTP inbounds.
+	SourceUser []string `protobuf:"bytes,12,rep,name=source_user,json=sourceUser" json:"source_user,omitempty"`
+	// JA3 fingerprints (hex encoded MD5) of sniffed TLS ClientHello messages.
+	Ja3 []string `protobuf:"bytes,13,rep,name=ja3" json:"ja3,omitempty"`
+	// Methods of sniffed HTTP requests, such as "GET" or "POST".
+	HttpMethod []string `protobuf:"bytes,14,rep,name=http_method,json=httpMethod" json:"http_method,omitempty"`
+	// Matches when the source IP has at least this many concurrent
+	// connections, including the current one. 0 means no limit.
+	MinSourceConnections uint32                  `protobuf:"varint,15,opt,name=min_source_connections,json=minSourceConnections" json:"min_source_connections,omitempty"`
+	SniffResult          RoutingRule_SniffResult `protobuf:"varint,16,opt,name=sniff_result,json=sniffResult,enum=v2ray.core.app.router.RoutingRule_SniffResult" json:"sniff_result,omitempty"`
+	Split                *Split                  `protobuf:"bytes,17,opt,name=split" json:"split,omitempty"`
+	// Names of port profiles in Config. Ports in the profiles are matched
+	// together with port_range.
+	PortProfile []string `protobuf:"bytes,18,rep,name=port_profile,json=portProfile" json:"port_profile,omitempty"`
+	// Marks a rule matching on inbound_tag alone as intended, so that it is not
+	// reported as an accidental match-all rule.
+	Intentional bool `protobuf:"varint,19,opt,name=intentional" json:"intentional,omitempty"`
+	// Headers of sniffed HTTP requests. The rule matches if any of them matches.
+	HttpHeader []*HTTPHeader `protobuf:"bytes,20,rep,name=http_header,json=httpHeader" json:"http_header,omitempty"`
+	// Matches destinations in the same subnet as the source. Domain
+	// destinations only match when resolved by the domain strategy.
+	SameSubnet *SameSubnet `protobuf:"bytes,21,opt,name=same_subnet,json=sameSubnet" json:"same_subnet,omitempty"`
+	// Domain strategy for this rule. If not set, the strategy in Config is used.
+	DomainStrategy *DomainStrategyOverride `protobuf:"bytes,22,opt,name=domain_strategy,json=domainStrategy" json:"domain_strategy,omitempty"`
+	// Highest TLS versions offered in sniffed TLS ClientHello messages, one of
+	// "1.0", "1.1", "1.2" and "1.3".
+	TlsVersion []string `protobuf:"bytes,23,rep,name=tls_version,json=tlsVersion" json:"tls_version,omitempty"`
+	// Names of CIDR sets in Config. IPs in the sets are matched together with
+	// cidr.
+	CidrSet []string `protobuf:"bytes,24,rep,name=cidr_set,json=cidrSet" json:"cidr_set,omitempty"`
+	// Matches only while the outbound of this rule has fewer active
+	// connections than this, so that overflow falls through to later rules.
+	// Only connections routed by the router are counted.
+	MaxOutboundConnections uint32 `protobuf:"varint,25,opt,name=max_outbound_connections,json=maxOutboundConnections" json:"max_outbound_connections,omitempty"`
+	// Matches domain destinations that have a label with high entropy.
+	HostnameEntropy *HostnameEntropy `protobuf:"bytes,26,opt,name=hostname_entropy,json=hostnameEntropy" json:"hostname_entropy,omitempty"`
+	// Transports the connection arrived over, any of "tcp", "udp", "mkcp" (or
+	// "kcp") and "websocket" (or "ws").
+	InboundTransport []string `protobuf:"bytes,27,rep,name=inbound_transport,json=inboundTransport" json:"inbound_transport,omitempty"`
+	// Port ranges assigned by IANA, any of "well-known" (0-1023), "registered"
+	// (1024-49151) and "dynamic" (49152-65535). Matched together with
+	// port_range and port_profile.
+	PortBucket []string `protobuf:"bytes,28,rep,name=port_bucket,json=portBucket" json:"port_bucket,omitempty"`
+	// Matches destinations reachable without a proxy.
+	DirectProbe *DirectProbe `protobuf:"bytes,29,opt,name=direct_probe,json=directProbe" json:"direct_probe,omitempty"`
+	// Matches the first connection from a source IP within a window. Only
+	// connections matching all other conditions of the rule are counted.
+	FirstSeen *FirstSeen `protobuf:"bytes,30,opt,name=first_seen,json=firstSeen" json:"first_seen,omitempty"`
+	// Asks the outbound to fragment the TLS ClientHello of connections routed
+	// by this rule. The router only passes the flag on in the route of the
+	// connection, for outbounds that support fragmenting.
+	FragmentClientHello bool `protobuf:"varint,31,opt,name=fragment_client_hello,json=fragmentClientHello" json:"fragment_client_hello,omitempty"`
 }
 
 func (m *RoutingRule) Reset()                    { *m = RoutingRule{} }
 func (m *RoutingRule) String() string            { return proto.CompactTextString(m) }
 func (*RoutingRule) ProtoMessage()               {}
-func (*RoutingRule) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*RoutingRule) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
 
 func (m *RoutingRule) GetTag() string {
 	if m != nil {
@@ -282,15 +677,200 @@ func (m *RoutingRule) GetInboundTag() []string {
 	return nil
 }
 
+func (m *RoutingRule) GetResolutionFailed() bool {
+	if m != nil {
+		return m.ResolutionFailed
+	}
+	return false
+}
+
+func (m *RoutingRule) GetNotProtocol() []string {
+	if m != nil {
+		return m.NotProtocol
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetRateLimit() *RateLimit {
+	if m != nil {
+		return m.RateLimit
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetSourceUser() []string {
+	if m != nil {
+		return m.SourceUser
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetJa3() []string {
+	if m != nil {
+		return m.Ja3
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetHttpMethod() []string {
+	if m != nil {
+		return m.HttpMethod
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetMinSourceConnections() uint32 {
+	if m != nil {
+		return m.MinSourceConnections
+	}
+	return 0
+}
+
+func (m *RoutingRule) GetSniffResult() RoutingRule_SniffResult {
+	if m != nil {
+		return m.SniffResult
+	}
+	return RoutingRule_Any
+}
+
+func (m *RoutingRule) GetSplit() *Split {
+	if m != nil {
+		return m.Split
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetPortProfile() []string {
+	if m != nil {
+		return m.PortProfile
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetIntentional() bool {
+	if m != nil {
+		return m.Intentional
+	}
+	return false
+}
+
+func (m *RoutingRule) GetHttpHeader() []*HTTPHeader {
+	if m != nil {
+		return m.HttpHeader
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetSameSubnet() *SameSubnet {
+	if m != nil {
+		return m.SameSubnet
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetDomainStrategy() *DomainStrategyOverride {
+	if m != nil {
+		return m.DomainStrategy
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetTlsVersion() []string {
+	if m != nil {
+		return m.TlsVersion
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetCidrSet() []string {
+	if m != nil {
+		return m.CidrSet
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetMaxOutboundConnections() uint32 {
+	if m != nil {
+		return m.MaxOutboundConnections
+	}
+	return 0
+}
+
+func (m *RoutingRule) GetHostnameEntropy() *HostnameEntropy {
+	if m != nil {
+		return m.HostnameEntropy
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetInboundTransport() []string {
+	if m != nil {
+		return m.InboundTransport
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetPortBucket() []string {
+	if m != nil {
+		return m.PortBucket
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetDirectProbe() *DirectProbe {
+	if m != nil {
+		return m.DirectProbe
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetFirstSeen() *FirstSeen {
+	if m != nil {
+		return m.FirstSeen
+	}
+	return nil
+}
+
+func (m *RoutingRule) GetFragmentClientHello() bool {
+	if m != nil {
+		return m.FragmentClientHello
+	}
+	return false
+}
+
 type Config struct {
 	DomainStrategy Config_DomainStrategy `protobuf:"varint,1,opt,name=domain_strategy,json=domainStrategy,enum=v2ray.core.app.router.Config_DomainStrategy" json:"domain_strategy,omitempty"`
 	Rule           []*RoutingRule        `protobuf:"bytes,2,rep,name=rule" json:"rule,omitempty"`
+	// Outbound tag to use when no rule matches. If empty, the default outbound
+	// handler is used.
+	DefaultOutboundTag string `protobuf:"bytes,3,opt,name=default_outbound_tag,json=defaultOutboundTag" json:"default_outbound_tag,omitempty"`
+	// Maximum number of rules, domains and CIDRs in all rules. The router
+	// fails to load if any of them is exceeded. 0 means unlimited. CIDRs
+	// include trusted sources, and CIDR sets once for each rule referencing them.
+	MaxRules   uint32 `protobuf:"varint,4,opt,name=max_rules,json=maxRules" json:"max_rules,omitempty"`
+	MaxDomains uint32 `protobuf:"varint,5,opt,name=max_domains,json=maxDomains" json:"max_domains,omitempty"`
+	MaxCidrs   uint32 `protobuf:"varint,6,opt,name=max_cidrs,json=maxCidrs" json:"max_cidrs,omitempty"`
+	// Port profiles that can be referenced by name from routing rules.
+	PortProfiles map[string]*PortList `protobuf:"bytes,7,rep,name=port_profiles,json=portProfiles" json:"port_profiles,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Lets all regex domains match any part of the domain, as if unanchored
+	// is set on each of them.
+	UnanchoredRegex bool `protobuf:"varint,8,opt,name=unanchored_regex,json=unanchoredRegex" json:"unanchored_regex,omitempty"`
+	// Sets of IP ranges that can be referenced by name from routing rules,
+	// such as ranges of CDN providers.
+	CidrSets map[string]*CIDRList `protobuf:"bytes,9,rep,name=cidr_sets,json=cidrSets" json:"cidr_sets,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// If set, all traffic to port 53 goes to this outbound, before any rule is
+	// evaluated.
+	DnsOutboundTag string `protobuf:"bytes,10,opt,name=dns_outbound_tag,json=dnsOutboundTag" json:"dns_outbound_tag,omitempty"`
+	// Connections from these sources go to trusted_outbound_tag, before any
+	// other rule is evaluated.
+	TrustedSource      []*CIDR `protobuf:"bytes,11,rep,name=trusted_source,json=trustedSource" json:"trusted_source,omitempty"`
+	TrustedOutboundTag string  `protobuf:"bytes,12,opt,name=trusted_outbound_tag,json=trustedOutboundTag" json:"trusted_outbound_tag,omitempty"`
 }
 
 func (m *Config) Reset()                    { *m = Config{} }
 func (m *Config) String() string            { return proto.CompactTextString(m) }
 func (*Config) ProtoMessage()               {}
-func (*Config) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (*Config) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
 
 func (m *Config) GetDomainStrategy() Config_DomainStrategy {
 	if m != nil {
@@ -306,6 +886,76 @@ func (m *Config) GetRule() []*RoutingRule {
 	return nil
 }
 
+func (m *Config) GetDefaultOutboundTag() string {
+	if m != nil {
+		return m.DefaultOutboundTag
+	}
+	return ""
+}
+
+func (m *Config) GetMaxRules() uint32 {
+	if m != nil {
+		return m.MaxRules
+	}
+	return 0
+}
+
+func (m *Config) GetMaxDomains() uint32 {
+	if m != nil {
+		return m.MaxDomains
+	}
+	return 0
+}
+
+func (m *Config) GetMaxCidrs() uint32 {
+	if m != nil {
+		return m.MaxCidrs
+	}
+	return 0
+}
+
+func (m *Config) GetPortProfiles() map[string]*PortList {
+	if m != nil {
+		return m.PortProfiles
+	}
+	return nil
+}
+
+func (m *Config) GetUnanchoredRegex() bool {
+	if m != nil {
+		return m.UnanchoredRegex
+	}
+	return false
+}
+
+func (m *Config) GetCidrSets() map[string]*CIDRList {
+	if m != nil {
+		return m.CidrSets
+	}
+	return nil
+}
+
+func (m *Config) GetDnsOutboundTag() string {
+	if m != nil {
+		return m.DnsOutboundTag
+	}
+	return ""
+}
+
+func (m *Config) GetTrustedSource() []*CIDR {
+	if m != nil {
+		return m.TrustedSource
+	}
+	return nil
+}
+
+func (m *Config) GetTrustedOutboundTag() string {
+	if m != nil {
+		return m.TrustedOutboundTag
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Domain)(nil), "v2ray.core.app.router.Domain")
 	proto.RegisterType((*CIDR)(nil), "v2ray.core.app.router.CIDR")
@@ -313,54 +963,133 @@ func init() {
 	proto.RegisterType((*GeoIPList)(nil), "v2ray.core.app.router.GeoIPList")
 	proto.RegisterType((*GeoSite)(nil), "v2ray.core.app.router.GeoSite")
 	proto.RegisterType((*GeoSiteList)(nil), "v2ray.core.app.router.GeoSiteList")
+	proto.RegisterType((*RateLimit)(nil), "v2ray.core.app.router.RateLimit")
+	proto.RegisterType((*FirstSeen)(nil), "v2ray.core.app.router.FirstSeen")
+	proto.RegisterType((*CIDRList)(nil), "v2ray.core.app.router.CIDRList")
+	proto.RegisterType((*PortList)(nil), "v2ray.core.app.router.PortList")
+	proto.RegisterType((*HTTPHeader)(nil), "v2ray.core.app.router.HTTPHeader")
+	proto.RegisterType((*SameSubnet)(nil), "v2ray.core.app.router.SameSubnet")
+	proto.RegisterType((*HostnameEntropy)(nil), "v2ray.core.app.router.HostnameEntropy")
+	proto.RegisterType((*DirectProbe)(nil), "v2ray.core.app.router.DirectProbe")
+	proto.RegisterType((*DomainStrategyOverride)(nil), "v2ray.core.app.router.DomainStrategyOverride")
+	proto.RegisterType((*Split)(nil), "v2ray.core.app.router.Split")
 	proto.RegisterType((*RoutingRule)(nil), "v2ray.core.app.router.RoutingRule")
 	proto.RegisterType((*Config)(nil), "v2ray.core.app.router.Config")
 	proto.RegisterEnum("v2ray.core.app.router.Domain_Type", Domain_Type_name, Domain_Type_value)
+	proto.RegisterEnum("v2ray.core.app.router.Split_Key", Split_Key_name, Split_Key_value)
+	proto.RegisterEnum("v2ray.core.app.router.RoutingRule_SniffResult", RoutingRule_SniffResult_name, RoutingRule_SniffResult_value)
 	proto.RegisterEnum("v2ray.core.app.router.Config_DomainStrategy", Config_DomainStrategy_name, Config_DomainStrategy_value)
 }
 
 func init() { proto.RegisterFile("v2ray.com/core/app/router/config.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 640 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x94, 0xcd, 0x6e, 0xd4, 0x3a,
-	0x14, 0xc7, 0x6f, 0xe6, 0xab, 0x9d, 0x93, 0xb9, 0x73, 0x23, 0xeb, 0x16, 0x0d, 0x85, 0xc2, 0x10,
-	0x21, 0x98, 0x05, 0x4a, 0xa4, 0xe1, 0x63, 0x05, 0xaa, 0xca, 0xb4, 0xaa, 0x22, 0x41, 0x19, 0xb9,
-	0x2d, 0x0b, 0x58, 0x44, 0x6e, 0xe2, 0x86, 0x88, 0x89, 0x6d, 0x39, 0x4e, 0xe9, 0xec, 0x78, 0x01,
-	0x5e, 0x84, 0xa7, 0xe2, 0x51, 0x90, 0xed, 0x0c, 0xb4, 0xa8, 0x81, 0x8a, 0x9d, 0xed, 0xfc, 0xfe,
-	0xe7, 0xfc, 0x73, 0x7c, 0x8e, 0xe1, 0xc1, 0xd9, 0x54, 0x92, 0x65, 0x90, 0xf0, 0x22, 0x4c, 0xb8,
-	0xa4, 0x21, 0x11, 0x22, 0x94, 0xbc, 0x52, 0x54, 0x86, 0x09, 0x67, 0xa7, 0x79, 0x16, 0x08, 0xc9,
-	0x15, 0x47, 0x1b, 0x2b, 0x4e, 0xd2, 0x80, 0x08, 0x11, 0x58, 0x66, 0xf3, 0xfe, 0x2f, 0xf2, 0x84,
-	0x17, 0x05, 0x67, 0x21, 0xa3, 0x2a, 0x14, 0x5c, 0x2a, 0x2b, 0xde, 0x7c, 0xd8, 0x4c, 0x31, 0xaa,
-	0x3e, 0x71, 0xf9, 0xd1, 0x82, 0xfe, 0x67, 0x07, 0x7a, 0xbb, 0xbc, 0x20, 0x39, 0x43, 0xcf, 0xa0,
-	0xa3, 0x96, 0x82, 0x8e, 0x9c, 0xb1, 0x33, 0x19, 0x4e, 0xfd, 0xe0, 0xca, 0xfc, 0x81, 0x85, 0x83,
-	0xa3, 0xa5, 0xa0, 0xd8, 0xf0, 0xe8, 0x7f, 0xe8, 0x9e, 0x91, 0x45, 0x45, 0x47, 0xad, 0xb1, 0x33,
-	0xe9, 0x63, 0xbb, 0xf1, 0x27, 0xd0, 0xd1, 0x0c, 0xea, 0x43, 0x77, 0xbe, 0x20, 0x39, 0xf3, 0xfe,
-	0xd1, 0x4b, 0x4c, 0x33, 0x7a, 0xee, 0x39, 0x08, 0x56, 0x59, 0xbd, 0x96, 0x1f, 0x40, 0x67, 0x16,
-	0xed, 0x62, 0x34, 0x84, 0x56, 0x2e, 0x4c, 0xf6, 0x01, 0x6e, 0xe5, 0x02, 0xdd, 0x80, 0x9e, 0x90,
-	0xf4, 0x34, 0x3f, 0x37, 0x81, 0xff, 0xc5, 0xf5, 0xce, 0x7f, 0x0f, 0xdd, 0x7d, 0xca, 0xa3, 0x39,
-	0xba, 0x07, 0x83, 0x84, 0x57, 0x4c, 0xc9, 0x65, 0x9c, 0xf0, 0xd4, 0x1a, 0xef, 0x63, 0xb7, 0x3e,
-	0x9b, 0xf1, 0x94, 0xa2, 0x10, 0x3a, 0x49, 0x9e, 0xca, 0x51, 0x6b, 0xdc, 0x9e, 0xb8, 0xd3, 0x5b,
-	0x0d, 0xff, 0xa4, 0xd3, 0x63, 0x03, 0xfa, 0xdb, 0xd0, 0x37, 0xc1, 0x5f, 0xe5, 0xa5, 0x42, 0x53,
-	0xe8, 0x52, 0x1d, 0x6a, 0xe4, 0x18, 0xf9, 0xed, 0x06, 0xb9, 0x11, 0x60, 0x8b, 0xfa, 0x09, 0xac,
-	0xed, 0x53, 0x7e, 0x98, 0x2b, 0x7a, 0x1d, 0x7f, 0x4f, 0xa1, 0x97, 0x9a, 0x3a, 0xd4, 0x0e, 0xb7,
-	0x7e, 0x5b, 0x75, 0x5c, 0xc3, 0xfe, 0x0c, 0xdc, 0x3a, 0x89, 0xf1, 0xf9, 0xe4, 0xb2, 0xcf, 0x3b,
-	0xcd, 0x3e, 0xb5, 0x64, 0xe5, 0xf4, 0x4b, 0x1b, 0x5c, 0xcc, 0x2b, 0x95, 0xb3, 0x0c, 0x57, 0x0b,
-	0x8a, 0x3c, 0x68, 0x2b, 0x92, 0xd5, 0x2e, 0xf5, 0xf2, 0x2f, 0xdd, 0xfd, 0x28, 0x7a, 0xfb, 0x9a,
-	0x45, 0x47, 0xdb, 0x00, 0xba, 0x77, 0x63, 0x49, 0x58, 0x46, 0x47, 0x9d, 0xb1, 0x33, 0x71, 0xa7,
-	0xe3, 0x8b, 0x32, 0xdb, 0xbe, 0x01, 0xa3, 0x2a, 0x98, 0x73, 0xa9, 0xb0, 0xe6, 0x70, 0x5f, 0xac,
-	0x96, 0x68, 0x0f, 0x06, 0x75, 0x5b, 0xc7, 0x8b, 0xbc, 0x54, 0xa3, 0xae, 0x09, 0xe1, 0x37, 0x84,
-	0x38, 0xb0, 0xa8, 0x2e, 0x1d, 0x76, 0xd9, 0xcf, 0x0d, 0x7a, 0x0e, 0x6e, 0xc9, 0x2b, 0x99, 0xd0,
-	0xd8, 0xf8, 0xef, 0xfd, 0xd9, 0x3f, 0x58, 0x7e, 0xa6, 0xff, 0x62, 0x0b, 0xa0, 0x2a, 0xa9, 0x8c,
-	0x69, 0x41, 0xf2, 0xc5, 0x68, 0x6d, 0xdc, 0x9e, 0xf4, 0x71, 0x5f, 0x9f, 0xec, 0xe9, 0x03, 0x74,
-	0x17, 0xdc, 0x9c, 0x9d, 0xf0, 0x8a, 0xa5, 0xb1, 0x2e, 0xf3, 0xba, 0xf9, 0x0e, 0xf5, 0xd1, 0x11,
-	0xc9, 0xfc, 0x6f, 0x0e, 0xf4, 0x66, 0xe6, 0x05, 0x40, 0xc7, 0xf0, 0x9f, 0xad, 0x65, 0x5c, 0x2a,
-	0x49, 0x14, 0xcd, 0x96, 0xf5, 0x54, 0x3e, 0x6a, 0x32, 0x63, 0x5f, 0x0e, 0x7b, 0x11, 0x87, 0xb5,
-	0x06, 0x0f, 0xd3, 0x4b, 0x7b, 0x3d, 0xe1, 0xb2, 0x5a, 0xd0, 0xfa, 0x36, 0x9b, 0x26, 0xfc, 0x42,
-	0x4f, 0x60, 0xc3, 0xfb, 0xfb, 0x30, 0xbc, 0x1c, 0x19, 0xad, 0x43, 0x67, 0xa7, 0x8c, 0x4a, 0x3b,
-	0xd4, 0xc7, 0x25, 0x8d, 0x84, 0xe7, 0x20, 0x0f, 0x06, 0x91, 0x88, 0x4e, 0x0f, 0x38, 0x7b, 0x4d,
-	0x54, 0xf2, 0xc1, 0x6b, 0xa1, 0x21, 0x40, 0x24, 0xde, 0xb0, 0x5d, 0x5a, 0x10, 0x96, 0x7a, 0xed,
-	0x97, 0x2f, 0xe0, 0x66, 0xc2, 0x8b, 0xab, 0xf3, 0xce, 0x9d, 0x77, 0x3d, 0xbb, 0xfa, 0xda, 0xda,
-	0x78, 0x3b, 0xc5, 0x64, 0x19, 0xcc, 0x34, 0xb1, 0x23, 0x84, 0xb1, 0x44, 0xe5, 0x49, 0xcf, 0xbc,
-	0x59, 0x8f, 0xbf, 0x07, 0x00, 0x00, 0xff, 0xff, 0x53, 0x7c, 0xa8, 0x94, 0x43, 0x05, 0x00, 0x00,
+	// 1705 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x57, 0xfd, 0x6e, 0xdb, 0xc8,
+	0x11, 0x8f, 0x24, 0x7f, 0x88, 0x23, 0xd9, 0x61, 0xf6, 0x12, 0x77, 0xe3, 0x7c, 0xe9, 0x88, 0xe2,
+	0xea, 0x22, 0xad, 0x5c, 0xe8, 0x12, 0xe3, 0xd0, 0x0f, 0x1c, 0x62, 0x3b, 0x17, 0x1b, 0x77, 0x97,
+	0xa8, 0x94, 0x93, 0x02, 0x6d, 0x01, 0x76, 0x4d, 0xae, 0x24, 0x36, 0xe4, 0x2e, 0xb1, 0xbb, 0x74,
+	0xac, 0x7f, 0xfb, 0x14, 0x7d, 0x86, 0xbe, 0x4b, 0x9f, 0xa0, 0x2f, 0x53, 0xcc, 0x2e, 0x69, 0x49,
+	0x49, 0xe4, 0x04, 0x07, 0xdc, 0x5f, 0xdc, 0x99, 0x9d, 0x99, 0x9d, 0x9d, 0xf9, 0xcd, 0xcc, 0x12,
+	0xbe, 0xba, 0x18, 0x28, 0x36, 0xeb, 0xc7, 0x32, 0xdf, 0x8f, 0xa5, 0xe2, 0xfb, 0xac, 0x28, 0xf6,
+	0x95, 0x2c, 0x0d, 0x57, 0xfb, 0xb1, 0x14, 0xe3, 0x74, 0xd2, 0x2f, 0x94, 0x34, 0x92, 0xdc, 0xa9,
+	0xe5, 0x14, 0xef, 0xb3, 0xa2, 0xe8, 0x3b, 0x99, 0xdd, 0x5f, 0xbe, 0xa7, 0x1e, 0xcb, 0x3c, 0x97,
+	0x62, 0x5f, 0x70, 0xb3, 0x5f, 0x48, 0x65, 0x9c, 0xf2, 0xee, 0xaf, 0x56, 0x4b, 0x09, 0x6e, 0xde,
+	0x49, 0xf5, 0xd6, 0x09, 0x06, 0xff, 0x6d, 0xc0, 0xc6, 0xb1, 0xcc, 0x59, 0x2a, 0xc8, 0x01, 0xac,
+	0x99, 0x59, 0xc1, 0x69, 0xa3, 0xd7, 0xd8, 0xdb, 0x1e, 0x04, 0xfd, 0x8f, 0x9e, 0xdf, 0x77, 0xc2,
+	0xfd, 0xb3, 0x59, 0xc1, 0x43, 0x2b, 0x4f, 0x6e, 0xc3, 0xfa, 0x05, 0xcb, 0x4a, 0x4e, 0x9b, 0xbd,
+	0xc6, 0x9e, 0x17, 0x3a, 0x82, 0x3c, 0x04, 0x28, 0x05, 0x13, 0xf1, 0x54, 0x2a, 0x9e, 0xd0, 0x56,
+	0xaf, 0xb1, 0xd7, 0x0e, 0x17, 0x38, 0xe4, 0x01, 0x40, 0x9e, 0x8a, 0x28, 0x63, 0xe7, 0x3c, 0xd3,
+	0x74, 0xad, 0xd7, 0xd8, 0xdb, 0x0a, 0xbd, 0x3c, 0x15, 0x3f, 0x58, 0x46, 0x70, 0x00, 0x6b, 0x78,
+	0x04, 0xf1, 0x60, 0x7d, 0x98, 0xb1, 0x54, 0xf8, 0x37, 0x70, 0x19, 0xf2, 0x09, 0xbf, 0xf4, 0x1b,
+	0x04, 0x6a, 0xa7, 0xfd, 0x26, 0xe9, 0x42, 0xfb, 0x2f, 0x69, 0x96, 0xc4, 0x4c, 0x25, 0x7e, 0x2b,
+	0xe8, 0xc3, 0xda, 0xd1, 0xe9, 0x71, 0x48, 0xb6, 0xa1, 0x99, 0x16, 0xf6, 0x2a, 0xdd, 0xb0, 0x99,
+	0x16, 0x64, 0x07, 0x36, 0x0a, 0xc5, 0xc7, 0xe9, 0xa5, 0xf5, 0x72, 0x2b, 0xac, 0xa8, 0xe0, 0x6f,
+	0xb0, 0xfe, 0x82, 0xcb, 0xd3, 0x21, 0xf9, 0x12, 0xba, 0xb1, 0x2c, 0x85, 0x51, 0xb3, 0x28, 0x96,
+	0x89, 0x8b, 0x82, 0x17, 0x76, 0x2a, 0xde, 0x91, 0x4c, 0x38, 0xd9, 0x87, 0xb5, 0x38, 0x4d, 0x14,
+	0x6d, 0xf6, 0x5a, 0x7b, 0x9d, 0xc1, 0xbd, 0x15, 0x01, 0xc2, 0xe3, 0x43, 0x2b, 0x18, 0x7c, 0x0b,
+	0x9e, 0x35, 0xfe, 0x43, 0xaa, 0x0d, 0x19, 0xc0, 0x3a, 0x47, 0x53, 0xb4, 0x61, 0xd5, 0xef, 0xaf,
+	0x50, 0xb7, 0x0a, 0xa1, 0x13, 0x0d, 0x62, 0xd8, 0x7c, 0xc1, 0xe5, 0x28, 0x35, 0xfc, 0x73, 0xfc,
+	0x7b, 0x0a, 0x1b, 0x89, 0x8d, 0x4a, 0xe5, 0xe1, 0x83, 0x6b, 0x53, 0x18, 0x56, 0xc2, 0xc1, 0x11,
+	0x74, 0xaa, 0x43, 0xac, 0x9f, 0x4f, 0x96, 0xfd, 0x7c, 0xb8, 0xda, 0x4f, 0x54, 0xa9, 0x3d, 0x7d,
+	0x0a, 0x5e, 0xc8, 0xd0, 0x42, 0x9e, 0x1a, 0x42, 0x60, 0x4d, 0x31, 0xe3, 0x7c, 0xdc, 0x0a, 0xed,
+	0x1a, 0x51, 0x72, 0x5e, 0x2a, 0x6d, 0xaa, 0xf8, 0x3b, 0x02, 0x23, 0xf4, 0x5d, 0xaa, 0xb4, 0x19,
+	0x71, 0x2e, 0x30, 0x47, 0xef, 0x52, 0x91, 0xc8, 0x77, 0x95, 0x62, 0x45, 0x91, 0x5d, 0x68, 0xc7,
+	0xac, 0x60, 0x71, 0x6a, 0x66, 0x95, 0xf6, 0x15, 0x1d, 0xfc, 0x01, 0xda, 0x18, 0x70, 0xeb, 0x79,
+	0x9d, 0x9f, 0xc6, 0xe7, 0xe6, 0xe7, 0x10, 0xda, 0x43, 0xa9, 0x8c, 0x55, 0x3e, 0x80, 0x75, 0xc5,
+	0xc4, 0x84, 0x57, 0xda, 0xbd, 0x45, 0x6d, 0x57, 0x3d, 0x7d, 0xc1, 0x4d, 0x1f, 0xe5, 0x43, 0x94,
+	0x0b, 0x9d, 0x78, 0x70, 0x00, 0x70, 0x72, 0x76, 0x36, 0x3c, 0xe1, 0x2c, 0xe1, 0x0a, 0x6f, 0x2e,
+	0x58, 0x5e, 0x67, 0xc7, 0xae, 0x3f, 0x5e, 0x1f, 0xc1, 0x4b, 0x80, 0x11, 0xcb, 0xf9, 0xa8, 0x3c,
+	0x17, 0xdc, 0x90, 0x47, 0xd0, 0x49, 0x8b, 0x8b, 0x27, 0x51, 0x85, 0x51, 0x77, 0x7f, 0x40, 0xd6,
+	0xd0, 0x72, 0x2a, 0x81, 0x83, 0x68, 0x09, 0xc4, 0x28, 0x70, 0xe0, 0x04, 0x82, 0x97, 0x70, 0xf3,
+	0x44, 0x6a, 0x83, 0x27, 0x3e, 0x17, 0x46, 0xc9, 0x62, 0x46, 0xee, 0x83, 0x67, 0xa6, 0x8a, 0xeb,
+	0xa9, 0xcc, 0x12, 0x6b, 0xb2, 0x11, 0xce, 0x19, 0x57, 0x05, 0xc8, 0xc5, 0xc4, 0x4c, 0x69, 0x73,
+	0x5e, 0x80, 0x96, 0x11, 0x1c, 0x43, 0xe7, 0x38, 0x55, 0x3c, 0x36, 0x43, 0x25, 0xcf, 0x39, 0xa1,
+	0xb0, 0x69, 0xd2, 0x9c, 0xcb, 0xd2, 0x54, 0xce, 0xd5, 0x24, 0xb9, 0x07, 0x5e, 0xcc, 0xe2, 0x29,
+	0x8f, 0x8c, 0xc9, 0xe6, 0xe9, 0x89, 0xa7, 0xfc, 0xcc, 0x64, 0x81, 0x84, 0x1d, 0x87, 0xb6, 0x91,
+	0x41, 0x14, 0x4c, 0x66, 0xaf, 0x2e, 0xb8, 0x52, 0x69, 0xc2, 0xc9, 0x6b, 0xb8, 0xe9, 0xf0, 0x17,
+	0xe9, 0x6a, 0xab, 0x6a, 0x3c, 0xbf, 0x59, 0x95, 0x37, 0xd7, 0x1c, 0x97, 0xcd, 0x85, 0xdb, 0xc9,
+	0x12, 0x1d, 0xfc, 0xab, 0x01, 0xeb, 0xa3, 0x22, 0x4b, 0xb1, 0xde, 0x5a, 0x6f, 0x79, 0x6d, 0xb4,
+	0xb7, 0xc2, 0xa8, 0x15, 0xed, 0x7f, 0xcf, 0x67, 0x21, 0x0a, 0xe3, 0x2d, 0x0b, 0xae, 0x62, 0x2e,
+	0x6a, 0x98, 0xd6, 0x64, 0xf0, 0x18, 0x5a, 0xdf, 0xf3, 0x19, 0x36, 0x9b, 0x91, 0x2c, 0x55, 0xcc,
+	0x4f, 0x87, 0xfe, 0x0d, 0x6c, 0x43, 0x8e, 0x72, 0x2d, 0xe9, 0x8c, 0xa9, 0x09, 0x37, 0x7e, 0x33,
+	0xf8, 0x5f, 0x17, 0x3a, 0xa1, 0x2c, 0x4d, 0x2a, 0x26, 0x61, 0x99, 0x71, 0xe2, 0x43, 0xcb, 0xb0,
+	0x49, 0x05, 0x0a, 0x5c, 0xfe, 0xc4, 0x52, 0xbd, 0x42, 0x78, 0xeb, 0x33, 0x11, 0x4e, 0xbe, 0x05,
+	0xc0, 0xa9, 0x10, 0x39, 0x68, 0x63, 0x97, 0xfd, 0x1c, 0x68, 0x7b, 0x45, 0xbd, 0x24, 0xcf, 0xa1,
+	0x5b, 0x0d, 0x8c, 0x28, 0x4b, 0xb5, 0xa1, 0xeb, 0xd6, 0x44, 0xb0, 0xc2, 0xc4, 0x4b, 0x27, 0x8a,
+	0x05, 0x15, 0x76, 0xc4, 0x9c, 0x20, 0x7f, 0x84, 0x8e, 0xb6, 0x91, 0x8a, 0xac, 0xff, 0x1b, 0x9f,
+	0xf6, 0x1f, 0x9c, 0xfc, 0x11, 0xde, 0xe2, 0x01, 0x40, 0xa9, 0xb9, 0x8a, 0x78, 0xce, 0xd2, 0x8c,
+	0x6e, 0xf6, 0x5a, 0x7b, 0x5e, 0xe8, 0x21, 0xe7, 0x39, 0x32, 0x6c, 0x6d, 0x88, 0x73, 0x59, 0x8a,
+	0x24, 0xc2, 0x30, 0xb7, 0xed, 0x3e, 0x54, 0xac, 0x33, 0x36, 0x21, 0x8f, 0xe1, 0x96, 0xe2, 0x5a,
+	0x66, 0xa5, 0x49, 0xa5, 0x88, 0xc6, 0x2c, 0xcd, 0x78, 0x42, 0x3d, 0x3b, 0x92, 0xfc, 0xf9, 0xc6,
+	0x77, 0x96, 0x8f, 0x8d, 0x56, 0x48, 0x13, 0xd9, 0xf1, 0x18, 0xcb, 0x8c, 0x82, 0x35, 0xd7, 0x11,
+	0xd2, 0x0c, 0x2b, 0x16, 0x46, 0x15, 0xe1, 0x16, 0x65, 0xd8, 0xed, 0x68, 0xe7, 0xc3, 0xa8, 0x2e,
+	0x5c, 0xe6, 0xaa, 0x2b, 0x86, 0x9e, 0xaa, 0x97, 0xe8, 0x71, 0x15, 0x0e, 0xbc, 0x05, 0xed, 0x3a,
+	0x8f, 0x1d, 0xeb, 0xb5, 0xe6, 0x0a, 0x11, 0xf3, 0x4f, 0xf6, 0x35, 0xdd, 0xb2, 0x1b, 0xb8, 0x44,
+	0x95, 0xa9, 0x31, 0x45, 0x94, 0x73, 0x33, 0x95, 0x09, 0xdd, 0x76, 0x2a, 0xc8, 0xfa, 0xd1, 0x72,
+	0xc8, 0x13, 0xd8, 0xc1, 0x7a, 0xae, 0xc3, 0x2c, 0x85, 0xe0, 0x31, 0x5e, 0x4b, 0xd3, 0x9b, 0x16,
+	0xca, 0xb7, 0xf3, 0x54, 0x38, 0xb4, 0x1e, 0xcd, 0xf7, 0xc8, 0x9f, 0xa1, 0xab, 0x45, 0x3a, 0x1e,
+	0x47, 0x8a, 0xeb, 0x32, 0x33, 0xd4, 0xb7, 0xe5, 0xd2, 0x5f, 0x75, 0x99, 0x39, 0xa8, 0xfb, 0x23,
+	0x54, 0x0b, 0xad, 0x56, 0xd8, 0xd1, 0x73, 0x02, 0x07, 0x9d, 0xc6, 0xb2, 0xa2, 0xb7, 0x7a, 0x8d,
+	0x6b, 0x06, 0x9d, 0x2d, 0xbd, 0xd0, 0x89, 0x62, 0xd0, 0x2d, 0x4e, 0x0b, 0x25, 0xc7, 0x69, 0xc6,
+	0x29, 0x71, 0x41, 0x47, 0xde, 0xd0, 0xb1, 0x48, 0x0f, 0xb3, 0x6c, 0xb8, 0x40, 0xbf, 0x59, 0x46,
+	0xbf, 0xb0, 0xe9, 0x5b, 0x64, 0x91, 0xc3, 0x2a, 0x44, 0x53, 0xdb, 0x8b, 0xe9, 0x6d, 0x0b, 0xb2,
+	0x2f, 0x57, 0x1c, 0x3f, 0x6f, 0xda, 0x2e, 0x8a, 0x6e, 0x8d, 0x36, 0x34, 0xcb, 0x79, 0xa4, 0x6d,
+	0x5f, 0xa6, 0x77, 0x7a, 0x8d, 0x6b, 0x6c, 0xcc, 0x1b, 0x78, 0x08, 0xfa, 0x6a, 0x4d, 0xde, 0x7c,
+	0xd8, 0xda, 0x76, 0xac, 0x9d, 0xdf, 0x5e, 0x5b, 0xe5, 0xef, 0xb7, 0xc8, 0xf7, 0x7b, 0x1b, 0x42,
+	0xc0, 0x64, 0x3a, 0xba, 0xe0, 0x4a, 0xa7, 0x52, 0xd0, 0x5f, 0x38, 0x08, 0x98, 0x4c, 0xbf, 0x71,
+	0x1c, 0x72, 0x17, 0xda, 0x58, 0x5e, 0x91, 0xe6, 0x86, 0x52, 0xbb, 0xbb, 0x89, 0xf4, 0x88, 0x1b,
+	0xf2, 0x0d, 0xd0, 0x9c, 0x5d, 0x46, 0xb2, 0x34, 0xae, 0x50, 0x16, 0xf1, 0x71, 0xd7, 0xe2, 0x63,
+	0x27, 0x67, 0x97, 0xaf, 0xaa, 0xed, 0x65, 0x84, 0xf8, 0xd3, 0x6a, 0xb0, 0x44, 0xdc, 0x4d, 0x16,
+	0xba, 0x6b, 0xaf, 0xf3, 0xd5, 0xaa, 0xd0, 0x2e, 0xcf, 0xa1, 0xf0, 0xe6, 0x74, 0x99, 0x81, 0xf5,
+	0x78, 0x55, 0xb0, 0x8a, 0x09, 0x8d, 0x69, 0xa6, 0xf7, 0xac, 0xc3, 0x7e, 0x5d, 0xb6, 0x35, 0x1f,
+	0x6f, 0x8d, 0xdf, 0xe8, 0xbc, 0x8c, 0xdf, 0x72, 0x43, 0xef, 0xbb, 0x5b, 0x23, 0xeb, 0xd0, 0x72,
+	0xb0, 0x45, 0x25, 0x76, 0x52, 0x21, 0x7a, 0xce, 0x39, 0x7d, 0xf0, 0x61, 0x8b, 0x5a, 0x8c, 0xf5,
+	0x7c, 0xa8, 0x85, 0x9d, 0x64, 0x4e, 0x60, 0x51, 0x8f, 0xf1, 0x29, 0x12, 0x69, 0xce, 0x05, 0x7d,
+	0x78, 0x6d, 0x51, 0x5f, 0xbd, 0x59, 0x42, 0x6f, 0x5c, 0x2f, 0xc9, 0x00, 0xee, 0x8c, 0x15, 0x9b,
+	0xe4, 0x5c, 0x98, 0x28, 0xce, 0x52, 0xfc, 0x4c, 0x79, 0x96, 0x49, 0xfa, 0xc8, 0x42, 0xf5, 0x8b,
+	0x7a, 0xf3, 0xc8, 0xee, 0x9d, 0xe0, 0x56, 0x30, 0x80, 0xce, 0x42, 0x1d, 0x91, 0x4d, 0x68, 0x3d,
+	0x13, 0x33, 0xff, 0x06, 0xe9, 0xc0, 0xa6, 0xe5, 0xf3, 0xc4, 0x6f, 0x90, 0x2d, 0xf0, 0x5e, 0x0b,
+	0x5d, 0x91, 0xcd, 0xe0, 0xdf, 0x9b, 0xb0, 0xe1, 0x86, 0xe1, 0xcf, 0x34, 0x44, 0xf1, 0x4f, 0x40,
+	0x95, 0x19, 0xaf, 0x66, 0x53, 0xf0, 0xe9, 0x66, 0x10, 0x5a, 0x79, 0xf2, 0x3b, 0xb8, 0x9d, 0xf0,
+	0x31, 0x2b, 0x33, 0x33, 0x07, 0x1a, 0x76, 0xe4, 0x96, 0x1d, 0x7c, 0xa4, 0xda, 0xab, 0x41, 0x86,
+	0x9d, 0xf9, 0x1e, 0x78, 0x08, 0x4b, 0xd4, 0xae, 0x7f, 0x02, 0xda, 0x39, 0xbb, 0x44, 0x9b, 0x1a,
+	0x33, 0x8f, 0x9b, 0xce, 0x39, 0x6d, 0x47, 0xcf, 0x56, 0x08, 0x39, 0xbb, 0x74, 0xee, 0xeb, 0x5a,
+	0x1b, 0x31, 0xae, 0xe9, 0xc6, 0x95, 0x36, 0xce, 0x0c, 0x4d, 0xce, 0x60, 0x6b, 0xb1, 0xa5, 0x68,
+	0x3b, 0x37, 0x3a, 0x83, 0xfd, 0xeb, 0x23, 0x33, 0x9c, 0x77, 0x1c, 0x8d, 0x70, 0x9d, 0x85, 0xdd,
+	0x85, 0x26, 0xa4, 0xc9, 0xaf, 0xc1, 0x9f, 0xff, 0xc4, 0x44, 0x0a, 0xff, 0x47, 0x68, 0xdb, 0xe6,
+	0xf7, 0xe6, 0x9c, 0x6f, 0x7f, 0x53, 0xc8, 0x09, 0x78, 0x75, 0x35, 0x6a, 0xea, 0xd9, 0xc3, 0x1f,
+	0x5f, 0x7f, 0xf8, 0x91, 0x2b, 0xd6, 0xea, 0xe0, 0x76, 0x55, 0xbb, 0x9a, 0xec, 0x81, 0x9f, 0x08,
+	0xbd, 0x1c, 0x53, 0xb0, 0x31, 0xdd, 0x4e, 0x84, 0x5e, 0x8c, 0xe7, 0x21, 0x6c, 0x1b, 0x55, 0x6a,
+	0xc3, 0x93, 0x6a, 0x10, 0xd0, 0xce, 0xa7, 0x47, 0xed, 0x56, 0xa5, 0xe2, 0xa6, 0x03, 0x66, 0xb1,
+	0xb6, 0xb1, 0x74, 0x62, 0xd7, 0x65, 0xb1, 0xda, 0x5b, 0x38, 0x75, 0xf7, 0x1f, 0x70, 0xeb, 0x83,
+	0xb8, 0x11, 0x7f, 0xfe, 0xfe, 0xf2, 0xdc, 0xeb, 0xea, 0xe9, 0xe2, 0x43, 0xb8, 0x33, 0x78, 0xb4,
+	0xc2, 0xa7, 0xfa, 0x49, 0x5e, 0xbd, 0x94, 0x7f, 0xdf, 0xfc, 0xa6, 0xb1, 0xfb, 0x77, 0xd8, 0x5a,
+	0x0a, 0xce, 0x4f, 0xb7, 0x5e, 0xff, 0x2d, 0x2c, 0x58, 0x0f, 0x5e, 0xc0, 0xf6, 0x72, 0x45, 0x90,
+	0x36, 0xac, 0x3d, 0xd3, 0xa7, 0xda, 0xfd, 0x75, 0xbe, 0xd6, 0xfc, 0xb4, 0xf0, 0x1b, 0xc4, 0x87,
+	0xee, 0x69, 0x71, 0x3a, 0x7e, 0x29, 0xc5, 0x8f, 0xcc, 0xc4, 0x53, 0xbf, 0x49, 0xb6, 0x01, 0x4e,
+	0x8b, 0x57, 0xe2, 0x98, 0xe7, 0x4c, 0x24, 0x7e, 0xeb, 0xf0, 0x4f, 0x70, 0x37, 0x96, 0xf9, 0xc7,
+	0x4f, 0x1e, 0x36, 0xfe, 0xba, 0xe1, 0x56, 0xff, 0x69, 0xde, 0x79, 0x33, 0x08, 0xd9, 0xac, 0x7f,
+	0x84, 0x12, 0xcf, 0x8a, 0xc2, 0x96, 0x12, 0x57, 0xe7, 0x1b, 0xf6, 0xd1, 0xf1, 0xf5, 0xff, 0x07,
+	0x00, 0xb5, 0x80, 0xcd, 0x3d, 0x23, 0x10, 0x00, 0x00,
 }
@@ -0,0 +1,69 @@
+package router
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// ToDOT returns a Graphviz graph of the routing rules, from inbound tags through rules to outbound tags.
+// Rules without inbound tags are connected to a node for any inbound.
+func (c *Config) ToDOT() string {
+	var b bytes.Buffer
+	b.WriteString("digraph routing {\n")
+
+	node := func(id string, label string, shape string) {
+		b.WriteString("  " + strconv.Quote(id) + " [label=" + strconv.Quote(label) + ", shape=" + shape + "];\n")
+	}
+	edge := func(from string, to string) {
+		b.WriteString("  " + strconv.Quote(from) + " -> " + strconv.Quote(to) + ";\n")
+	}
+
+	const anyInbound = "inbound:*"
+	node(anyInbound, "any inbound", "ellipse")
+
+	seen := make(map[string]bool)
+	inbound := func(tag string) string {
+		id := "inbound:" + tag
+		if !seen[id] {
+			seen[id] = true
+			node(id, tag, "ellipse")
+		}
+		return id
+	}
+	outbound := func(tag string) string {
+		id := "outbound:" + tag
+		if !seen[id] {
+			seen[id] = true
+			node(id, tag, "box")
+		}
+		return id
+	}
+	special := func(id string, label string, tag string) {
+		if len(tag) == 0 {
+			return
+		}
+		node(id, label, "diamond")
+		edge(anyInbound, id)
+		edge(id, outbound(tag))
+	}
+
+	special("trusted", "trusted source", c.TrustedOutboundTag)
+	special("dns", "dns", c.DnsOutboundTag)
+	for idx, rule := range c.Rule {
+		id := "rule:" + strconv.Itoa(idx)
+		node(id, "rule "+strconv.Itoa(idx), "diamond")
+		if len(rule.InboundTag) == 0 {
+			edge(anyInbound, id)
+		}
+		for _, tag := range rule.InboundTag {
+			edge(inbound(tag), id)
+		}
+		if len(rule.Tag) > 0 {
+			edge(id, outbound(rule.Tag))
+		}
+	}
+	special("default", "default", c.DefaultOutboundTag)
+
+	b.WriteString("}\n")
+	return b.String()
+}
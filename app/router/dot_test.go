@@ -0,0 +1,51 @@
+package router_test
+
+import (
+	"testing"
+
+	. "v2ray.com/core/app/router"
+	"v2ray.com/core/common/net"
+	. "v2ray.com/ext/assert"
+)
+
+func TestConfigToDOT(t *testing.T) {
+	assert := With(t)
+
+	config := &Config{
+		DefaultOutboundTag: "proxy",
+		DnsOutboundTag:     "dns-out",
+		Rule: []*RoutingRule{
+			{
+				Tag:        "direct",
+				InboundTag: []string{"socks", "http"},
+				PortRange:  net.SinglePortRange(80),
+			},
+			{
+				Tag:       "block",
+				PortRange: net.SinglePortRange(25),
+			},
+		},
+	}
+
+	dot := config.ToDOT()
+	assert(dot, HasPrefix, "digraph routing {\n")
+	assert(dot, HasSuffix, "}\n")
+	for _, line := range []string{
+		`"inbound:socks" [label="socks", shape=ellipse];`,
+		`"rule:0" [label="rule 0", shape=diamond];`,
+		`"outbound:direct" [label="direct", shape=box];`,
+		`"inbound:socks" -> "rule:0";`,
+		`"inbound:http" -> "rule:0";`,
+		`"rule:0" -> "outbound:direct";`,
+		`"inbound:*" -> "rule:1";`,
+		`"rule:1" -> "outbound:block";`,
+		`"inbound:*" -> "dns";`,
+		`"dns" -> "outbound:dns-out";`,
+		`"inbound:*" -> "default";`,
+		`"default" -> "outbound:proxy";`,
+	} {
+		assert(dot, HasSubstring, "  "+line+"\n")
+	}
+	assert(dot, Not(HasSubstring), `"inbound:*" -> "rule:0"`)
+	assert(dot, Not(HasSubstring), `"trusted"`)
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// metrics holds the counters served by Router.MetricsHandler. All fields are updated atomically.
+type metrics struct {
+	unmatched          uint64
+	dnsMatches         uint64
+	trustedMatches     uint64
+	resolved           uint64
+	resolveFailed      uint64
+	resolveCacheHits   uint64
+	ruleMatches        []uint64
+	ruleTags           []string
+	defaultOutboundTag string
+	dnsOutboundTag     string
+	trustedOutboundTag string
+}
+
+func newMetrics(rules []Rule, defaultTag string) *metrics {
+	m := &metrics{
+		ruleMatches:        make([]uint64, len(rules)),
+		ruleTags:           make([]string, len(rules)),
+		defaultOutboundTag: defaultTag,
+	}
+	for idx := range rules {
+		m.ruleTags[idx] = rules[idx].Tag
+	}
+	return m
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func writeMetric(b *bytes.Buffer, name string, help string, samples ...string) {
+	b.WriteString("# HELP " + name + " " + help + "\n")
+	b.WriteString("# TYPE " + name + " counter\n")
+	for _, s := range samples {
+		b.WriteString(name + s + "\n")
+	}
+}
+
+func sample(value uint64, labels ...string) string {
+	var b bytes.Buffer
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i] + "=\"" + labelEscaper.Replace(labels[i+1]) + "\"")
+		}
+		b.WriteByte('}')
+	}
+	b.WriteString(" " + strconv.FormatUint(value, 10))
+	return b.String()
+}
+
+func (m *metrics) writeTo(b *bytes.Buffer) {
+	rules := make([]string, len(m.ruleMatches))
+	for idx := range m.ruleMatches {
+		rules[idx] = sample(atomic.LoadUint64(&m.ruleMatches[idx]), "rule", strconv.Itoa(idx), "outbound", m.ruleTags[idx])
+	}
+	writeMetric(b, "v2ray_router_rule_matches_total", "Number of connections matched by each routing rule.", rules...)
+	writeMetric(b, "v2ray_router_unmatched_total", "Number of connections not matched by any routing rule.",
+		sample(atomic.LoadUint64(&m.unmatched), "outbound", m.defaultOutboundTag))
+	if len(m.trustedOutboundTag) > 0 {
+		writeMetric(b, "v2ray_router_trusted_source_matches_total", "Number of connections from trusted sources.",
+			sample(atomic.LoadUint64(&m.trustedMatches), "outbound", m.trustedOutboundTag))
+	}
+	if len(m.dnsOutboundTag) > 0 {
+		writeMetric(b, "v2ray_router_dns_matches_total", "Number of connections to port 53 routed to the DNS outbound.",
+			sample(atomic.LoadUint64(&m.dnsMatches), "outbound", m.dnsOutboundTag))
+	}
+	writeMetric(b, "v2ray_router_resolutions_total", "Number of domains resolved for routing, by result.",
+		sample(atomic.LoadUint64(&m.resolved), "result", "success"),
+		sample(atomic.LoadUint64(&m.resolveFailed), "result", "failure"),
+		sample(atomic.LoadUint64(&m.resolveCacheHits), "result", "cached_failure"))
+}
+
+// MetricsHandler returns a HTTP handler that serves routing metrics in Prometheus text format.
+func (r *Router) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var b bytes.Buffer
+		r.metrics.writeTo(&b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(b.Bytes())
+	})
+}
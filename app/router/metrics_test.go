@@ -0,0 +1,136 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"v2ray.com/core/common/errors"
+	"v2ray.com/core/common/net"
+	"v2ray.com/core/proxy"
+	. "v2ray.com/ext/assert"
+)
+
+func TestDNSRouteMetrics(t *testing.T) {
+	assert := With(t)
+
+	r, err := newRouter(&Config{
+		DnsOutboundTag:     "dns-out",
+		DefaultOutboundTag: "fallback",
+	}, mapDNSClient{})
+	assert(err, IsNil)
+
+	d := r.pick(proxy.ContextWithTarget(context.Background(), net.UDPDestination(net.ParseAddress("8.8.8.8"), 53)))
+	assert(d.OutboundTag, Equals, "dns-out")
+	assert(d.RuleIndex, Equals, -1)
+	assert(d.Reason, Equals, RouteReasonDNS)
+	assert(d.String(), HasSubstring, "reason=dns")
+
+	d = r.pick(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("8.8.8.8"), 443)))
+	assert(d.OutboundTag, Equals, "fallback")
+	assert(d.Reason, Equals, RouteReasonDefault)
+
+	var b bytes.Buffer
+	r.metrics.writeTo(&b)
+	assert(b.String(), HasSubstring, "v2ray_router_dns_matches_total{outbound=\"dns-out\"} 1\n")
+	assert(b.String(), HasSubstring, "v2ray_router_unmatched_total{outbound=\"fallback\"} 1\n")
+}
+
+func TestTrustedRouteMetrics(t *testing.T) {
+	assert := With(t)
+
+	r, err := newRouter(&Config{
+		TrustedSource:      []*CIDR{{Ip: []byte{192, 168, 0, 0}, Prefix: 16}},
+		TrustedOutboundTag: "direct",
+		DnsOutboundTag:     "dns-out",
+	}, mapDNSClient{})
+	assert(err, IsNil)
+
+	ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress("192.168.1.2"), 10000))
+	d := r.pick(proxy.ContextWithTarget(ctx, net.UDPDestination(net.ParseAddress("8.8.8.8"), 53)))
+	assert(d.OutboundTag, Equals, "direct")
+	assert(d.RuleIndex, Equals, -1)
+	assert(d.Reason, Equals, RouteReasonTrusted)
+
+	var b bytes.Buffer
+	r.metrics.writeTo(&b)
+	assert(b.String(), HasSubstring, "v2ray_router_trusted_source_matches_total{outbound=\"direct\"} 1\n")
+	assert(b.String(), HasSubstring, "v2ray_router_dns_matches_total{outbound=\"dns-out\"} 0\n")
+}
+
+type mapDNSClient map[string][]net.IP
+
+func (mapDNSClient) Start() error { return nil }
+func (mapDNSClient) Close()       {}
+
+func (c mapDNSClient) LookupIP(host string) ([]net.IP, error) {
+	if ips, found := c[host]; found {
+		return ips, nil
+	}
+	return nil, errors.New("NXDOMAIN: ", host)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	assert := With(t)
+
+	r, err := newRouter(&Config{
+		DomainStrategy:     Config_IpIfNonMatch,
+		DefaultOutboundTag: "fallback",
+		Rule: []*RoutingRule{
+			{
+				Tag:       "dns",
+				PortRange: net.SinglePortRange(53),
+			},
+			{
+				Tag: "local",
+				Cidr: []*CIDR{
+					{
+						Ip:     []byte{127, 0, 0, 0},
+						Prefix: 8,
+					},
+				},
+			},
+		},
+	}, mapDNSClient{
+		"v2ray.com": {net.IP{127, 0, 0, 1}},
+	})
+	assert(err, IsNil)
+
+	pick := func(domain string, port net.Port) {
+		_, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(domain), port)))
+		assert(err, IsNil)
+	}
+	pick("v2ray.com", 53)
+	pick("v2ray.com", 53)
+	pick("v2ray.com", 80)
+	pick("nx.v2ray.com", 80)
+	pick("nx.v2ray.com", 80)
+
+	rec := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert(rec.Header().Get("Content-Type"), HasPrefix, "text/plain")
+	body, err := ioutil.ReadAll(rec.Body)
+	assert(err, IsNil)
+	lines := strings.Split(string(body), "\n")
+
+	for _, line := range []string{
+		"# TYPE v2ray_router_rule_matches_total counter",
+		`v2ray_router_rule_matches_total{rule="0",outbound="dns"} 2`,
+		`v2ray_router_rule_matches_total{rule="1",outbound="local"} 1`,
+		`v2ray_router_unmatched_total{outbound="fallback"} 2`,
+		`v2ray_router_resolutions_total{result="success"} 1`,
+		`v2ray_router_resolutions_total{result="failure"} 1`,
+		`v2ray_router_resolutions_total{result="cached_failure"} 1`,
+	} {
+		found := false
+		for _, l := range lines {
+			if l == line {
+				found = true
+			}
+		}
+		assert(found, IsTrue)
+	}
+}
@@ -4,17 +4,33 @@ package router
 
 import (
 	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"v2ray.com/core"
 	"v2ray.com/core/common"
+	"v2ray.com/core/common/log"
 	"v2ray.com/core/common/net"
+	"v2ray.com/core/common/serial"
 	"v2ray.com/core/proxy"
 )
 
 type Router struct {
-	domainStrategy Config_DomainStrategy
-	rules          []Rule
-	dns            core.DNSClient
+	// trustedRule and dnsRule are evaluated before all rules, if set.
+	trustedRule   *Rule
+	dnsRule       *Rule
+	rules         []Rule
+	defaultTag    string
+	dns           core.DNSClient
+	failedDomains *negativeCache
+	connections   *connCounter
+	outbounds     *connCounter
+	metrics       *metrics
+	// sniffWarned records rules already warned about for matching on domain without sniffing.
+	sniffWarned []uint32
 }
 
 func NewRouter(ctx context.Context, config *Config) (*Router, error) {
@@ -23,32 +39,168 @@ func NewRouter(ctx context.Context, config *Config) (*Router, error) {
 		return nil, newError("V is not in context")
 	}
 
+	r, err := newRouter(config, v.DNSClient())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.RegisterFeature((*core.Router)(nil), r); err != nil {
+		return nil, newError("unable to register Router").Base(err)
+	}
+	return r, nil
+}
+
+func newRouter(config *Config, dns core.DNSClient) (*Router, error) {
+	if err := config.checkLimits(); err != nil {
+		return nil, err
+	}
+	for _, err := range config.Lint() {
+		newError(err).AtWarning().WriteToLog()
+	}
+
 	r := &Router{
-		domainStrategy: config.DomainStrategy,
-		rules:          make([]Rule, len(config.Rule)),
-		dns:            v.DNSClient(),
+		rules:         make([]Rule, len(config.Rule)),
+		sniffWarned:   make([]uint32, len(config.Rule)),
+		defaultTag:    config.DefaultOutboundTag,
+		dns:           dns,
+		failedDomains: newNegativeCache(),
 	}
 
 	for idx, rule := range config.Rule {
-		r.rules[idx].Tag = rule.Tag
-		cond, err := rule.BuildCondition()
+		if rule.MinSourceConnections > 0 && r.connections == nil {
+			r.connections = newConnCounter()
+		}
+		if rule.MaxOutboundConnections > 0 && r.outbounds == nil {
+			r.outbounds = newConnCounter()
+		}
+		compiled, err := rule.build(config)
 		if err != nil {
-			return nil, err
+			return nil, withRuleIndex(err, idx)
 		}
-		r.rules[idx].Condition = cond
+		r.rules[idx] = *compiled
 	}
+	r.metrics = newMetrics(r.rules, r.defaultTag)
+	r.metrics.dnsOutboundTag = config.DnsOutboundTag
+	r.metrics.trustedOutboundTag = config.TrustedOutboundTag
 
-	if err := v.RegisterFeature((*core.Router)(nil), r); err != nil {
-		return nil, newError("unable to register Router").Base(err)
+	if len(config.TrustedSource) > 0 {
+		if len(config.TrustedOutboundTag) == 0 {
+			return nil, newError("trusted outbound tag is not set")
+		}
+		cond, err := cidrToCondition(config.TrustedSource, true)
+		if err != nil {
+			return nil, newError("invalid trusted source").Base(err)
+		}
+		r.trustedRule = &Rule{
+			Tag:       config.TrustedOutboundTag,
+			Condition: cond,
+		}
+	}
+
+	if len(config.DnsOutboundTag) > 0 {
+		r.dnsRule = &Rule{
+			Tag:       config.DnsOutboundTag,
+			Condition: NewPortMatcher(*net.SinglePortRange(53)),
+		}
 	}
 	return r, nil
 }
 
+const negativeCacheTTL = time.Second * 30
+
+// negativeCache remembers domains that recently failed to resolve, so that
+// repeated connections to a dead domain don't hit DNS every time.
+type negativeCache struct {
+	sync.Mutex
+	expire   map[string]time.Time
+	lastScan time.Time
+}
+
+func newNegativeCache() *negativeCache {
+	return &negativeCache{
+		expire: make(map[string]time.Time, 16),
+	}
+}
+
+func (c *negativeCache) Has(domain string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	expire, found := c.expire[domain]
+	if !found {
+		return false
+	}
+	if time.Now().After(expire) {
+		delete(c.expire, domain)
+		return false
+	}
+	return true
+}
+
+func (c *negativeCache) Add(domain string) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	c.expire[domain] = now.Add(negativeCacheTTL)
+
+	if len(c.expire) > 256 && now.Sub(c.lastScan) > negativeCacheTTL {
+		for k, v := range c.expire {
+			if now.After(v) {
+				delete(c.expire, k)
+			}
+		}
+		c.lastScan = now
+	}
+}
+
+// connCounter counts concurrent connections by key, such as source IP or outbound tag.
+type connCounter struct {
+	sync.Mutex
+	count map[string]int
+}
+
+func newConnCounter() *connCounter {
+	return &connCounter{
+		count: make(map[string]int, 64),
+	}
+}
+
+// Track counts a connection under the given name until release is called, and returns the number
+// of connections under the name, including this one.
+func (c *connCounter) Track(name string) (int, func()) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.count[name]++
+	return c.count[name], func() {
+		c.Lock()
+		defer c.Unlock()
+
+		c.count[name]--
+		if c.count[name] <= 0 {
+			delete(c.count, name)
+		}
+	}
+}
+
+// Count returns the number of connections under the given name.
+func (c *connCounter) Count(name string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.count[name]
+}
+
 type ipResolver struct {
 	dns      core.DNSClient
+	failed   *negativeCache
 	ip       []net.Address
 	domain   string
 	resolved bool
+	metrics  *metrics
+	// dryRun keeps failed domains out of the negative cache.
+	dryRun bool
 }
 
 func (r *ipResolver) Resolve() []net.Address {
@@ -56,15 +208,25 @@ func (r *ipResolver) Resolve() []net.Address {
 		return r.ip
 	}
 
-	newError("looking for IP for domain: ", r.domain).WriteToLog()
 	r.resolved = true
+	if r.failed.Has(r.domain) {
+		atomic.AddUint64(&r.metrics.resolveCacheHits, 1)
+		return nil
+	}
+
+	newError("looking for IP for domain: ", r.domain).WriteToLog()
 	ips, err := r.dns.LookupIP(r.domain)
 	if err != nil {
 		newError("failed to get IP address").Base(err).WriteToLog()
 	}
 	if len(ips) == 0 {
+		atomic.AddUint64(&r.metrics.resolveFailed, 1)
+		if !r.dryRun {
+			r.failed.Add(r.domain)
+		}
 		return nil
 	}
+	atomic.AddUint64(&r.metrics.resolved, 1)
 	r.ip = make([]net.Address, len(ips))
 	for i, ip := range ips {
 		r.ip[i] = net.IPAddress(ip)
@@ -72,37 +234,236 @@ func (r *ipResolver) Resolve() []net.Address {
 	return r.ip
 }
 
+// RouteDecision is the result of routing a connection. It is logged for each connection routed by PickRoute.
+type RouteDecision struct {
+	Destination net.Destination
+	// RuleIndex is the index of the matched rule, or -1 if no rule matched.
+	RuleIndex int
+	// OutboundTag is the picked outbound, or empty if Err is set.
+	OutboundTag string
+	// ResolvedIPs are the IPs of the destination domain used for matching, if it was resolved.
+	ResolvedIPs []net.Address
+	// Reason tells how the outbound was picked when it was not by a rule. It is empty otherwise.
+	Reason RouteReason
+	// SniffingDisabled is set if the inbound doesn't sniff, so domain rules can't match IP destinations.
+	SniffingDisabled bool
+	Err              error
+}
+
+// RouteReason is the reason of a RouteDecision that was not made by a rule.
+type RouteReason string
+
+const (
+	// RouteReasonTrusted is for connections from trusted sources, routed to the trusted outbound.
+	RouteReasonTrusted RouteReason = "trusted"
+	// RouteReasonDNS is for connections to port 53 routed to the DNS outbound.
+	RouteReasonDNS RouteReason = "dns"
+	// RouteReasonDefault is for connections that no rule matched, routed to the default outbound.
+	RouteReasonDefault RouteReason = "default"
+)
+
+func (d *RouteDecision) String() string {
+	s := serial.Concat("route destination=", d.Destination, " rule=", d.RuleIndex, " outbound=", d.OutboundTag)
+	if len(d.Reason) > 0 {
+		s += serial.Concat(" reason=", string(d.Reason))
+	}
+	if len(d.ResolvedIPs) > 0 {
+		s += serial.Concat(" resolved=", d.ResolvedIPs)
+	}
+	if d.Err != nil {
+		s += serial.Concat(" error=", d.Err)
+	}
+	return s
+}
+
 func (r *Router) PickRoute(ctx context.Context) (string, error) {
-	resolver := &ipResolver{
-		dns: r.dns,
+	d := r.pick(ctx)
+	log.Record(&log.GeneralMessage{
+		Severity: log.Severity_Info,
+		Content:  d,
+	})
+	return d.OutboundTag, d.Err
+}
+
+func (r *Router) pick(ctx context.Context) *RouteDecision {
+	// Connections are counted until their route is closed. Without a route, the connection
+	// is counted only while it is routed.
+	route := proxy.RouteFromContext(ctx)
+
+	if r.connections != nil {
+		if src, ok := proxy.SourceFromContext(ctx); ok && !src.Address.Family().IsDomain() {
+			ip := src.Address.String()
+			n := r.connections.Count(ip) + 1
+			if route != nil {
+				var release func()
+				n, release = r.connections.Track(ip)
+				route.OnClose(release)
+			}
+			ctx = contextWithSourceConnections(ctx, n)
+		}
+	}
+
+	if r.outbounds != nil {
+		ctx = contextWithOutboundConnections(ctx, r.outbounds)
+	}
+
+	d := &RouteDecision{
+		RuleIndex:        -1,
+		SniffingDisabled: !proxy.SniffingEnabledFromContext(ctx),
+	}
+	if dest, ok := proxy.TargetFromContext(ctx); ok {
+		d.Destination = dest
+	}
+
+	// Simulated routing is counted in metrics that are thrown away.
+	m := r.metrics
+	if isDryRun(ctx) {
+		m = newMetrics(r.rules, r.defaultTag)
+	}
+
+	tag, err := r.pickRouteInternal(ctx, d, m)
+	if err == core.ErrNoClue {
+		atomic.AddUint64(&m.unmatched, 1)
+	}
+	if err == core.ErrNoClue && len(r.defaultTag) > 0 {
+		tag, err = r.defaultTag, nil
+		d.Reason = RouteReasonDefault
+	}
+	d.OutboundTag, d.Err = tag, err
+	if route != nil {
+		if r.outbounds != nil && err == nil {
+			_, release := r.outbounds.Track(tag)
+			route.OnClose(release)
+		}
+		route.RuleIndex = d.RuleIndex
+		if d.RuleIndex >= 0 {
+			route.FragmentClientHello = r.rules[d.RuleIndex].fragmentClientHello
+		}
+		if err == nil {
+			route.OutboundTag = tag
+		}
+	}
+	return d
+}
+
+// matchRule counts the match of a rule, and records it in the decision.
+func (r *Router) matchRule(m *metrics, d *RouteDecision, idx int) string {
+	atomic.AddUint64(&m.ruleMatches[idx], 1)
+	d.RuleIndex = idx
+	return r.rules[idx].Tag
+}
+
+// parseNumericIPv4 parses the numeric IPv4 forms accepted by inet_aton(3), such as
+// "0x7f000001", "2130706433", "0177.0.0.1" or "127.1". It returns nil if s is not such a form.
+func parseNumericIPv4(s string) net.IP {
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return nil
 	}
-	if r.domainStrategy == Config_IpOnDemand {
-		if dest, ok := proxy.TargetFromContext(ctx); ok && dest.Address.Family().IsDomain() {
-			resolver.domain = dest.Address.Domain()
-			ctx = proxy.ContextWithResolveIPs(ctx, resolver)
+	values := make([]uint64, len(parts))
+	for i, p := range parts {
+		base := 10
+		switch {
+		case len(p) > 2 && (p[:2] == "0x" || p[:2] == "0X"):
+			base = 16
+			p = p[2:]
+		case len(p) > 1 && p[0] == '0':
+			base = 8
+			p = p[1:]
 		}
+		v, err := strconv.ParseUint(p, base, 32)
+		if err != nil {
+			return nil
+		}
+		values[i] = v
 	}
 
-	for _, rule := range r.rules {
-		if rule.Apply(ctx) {
-			return rule.Tag, nil
+	var ip uint64
+	last := len(values) - 1
+	for i, v := range values[:last] {
+		if v > 0xff {
+			return nil
 		}
+		ip |= v << uint(24-8*i)
+	}
+	if values[last] >= 1<<uint(32-8*last) {
+		return nil
 	}
+	ip |= values[last]
+	return net.IP{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}
+}
 
-	dest, ok := proxy.TargetFromContext(ctx)
-	if !ok {
-		return "", core.ErrNoClue
+func (r *Router) pickRouteInternal(ctx context.Context, d *RouteDecision, m *metrics) (string, error) {
+	resolver := &ipResolver{
+		dns:     r.dns,
+		failed:  r.failedDomains,
+		metrics: m,
+		dryRun:  isDryRun(ctx),
+	}
+	defer func() {
+		if resolver.resolved {
+			d.ResolvedIPs = resolver.ip
+		}
+	}()
+
+	if r.trustedRule != nil && r.trustedRule.Apply(ctx) {
+		atomic.AddUint64(&m.trustedMatches, 1)
+		d.Reason = RouteReasonTrusted
+		return r.trustedRule.Tag, nil
 	}
+	if r.dnsRule != nil && r.dnsRule.Apply(ctx) {
+		atomic.AddUint64(&m.dnsMatches, 1)
+		d.Reason = RouteReasonDNS
+		return r.dnsRule.Tag, nil
+	}
+
+	dest, ok := proxy.TargetFromContext(ctx)
+	isDomain := ok && dest.Address.Family().IsDomain()
 
-	if r.domainStrategy == Config_IpIfNonMatch && dest.Address.Family().IsDomain() {
+	// resolveCtx is used by rules that resolve domains. The resolver is shared, so a domain
+	// is resolved at most once.
+	resolveCtx := ctx
+	if isDomain {
 		resolver.domain = dest.Address.Domain()
-		ips := resolver.Resolve()
-		if len(ips) > 0 {
-			ctx = proxy.ContextWithResolveIPs(ctx, resolver)
-			for _, rule := range r.rules {
-				if rule.Apply(ctx) {
-					return rule.Tag, nil
-				}
+		resolveCtx = proxy.ContextWithResolveIPs(ctx, resolver)
+
+		// Domains that are IPv4 addresses in disguise are matched as the address they stand for.
+		if ip := parseNumericIPv4(dest.Address.Domain()); ip != nil {
+			resolver.ip = []net.Address{net.IPAddress(ip)}
+			resolver.resolved = true
+			ctx = resolveCtx
+		}
+	}
+
+	unsniffed := ok && !isDomain && d.SniffingDisabled
+
+	hasIfNonMatch := false
+	for idx := range r.rules {
+		rule := &r.rules[idx]
+		if unsniffed && rule.domain != nil && !resolver.dryRun && atomic.CompareAndSwapUint32(&r.sniffWarned[idx], 0, 1) {
+			newError("rule ", idx, " matches on domain, but sniffing is disabled for ", dest, ", so the domain is unknown").AtWarning().WriteToLog()
+		}
+		ruleCtx := ctx
+		switch rule.domainStrategy {
+		case Config_IpOnDemand:
+			ruleCtx = resolveCtx
+		case Config_IpIfNonMatch:
+			hasIfNonMatch = true
+		}
+		if rule.Apply(ruleCtx) {
+			return r.matchRule(m, d, idx), nil
+		}
+	}
+
+	if hasIfNonMatch && isDomain {
+		// Rules are re-evaluated even if resolution failed, as some rules match on the failure itself.
+		// Rules that didn't match in the first pass haven't changed any state, see ConditionChan,
+		// so connections are not counted twice by rate limits and the like.
+		resolver.Resolve()
+		for idx := range r.rules {
+			rule := &r.rules[idx]
+			if rule.domainStrategy == Config_IpIfNonMatch && rule.Apply(resolveCtx) {
+				return r.matchRule(m, d, idx), nil
 			}
 		}
 	}
@@ -10,6 +10,8 @@ import (
 	_ "v2ray.com/core/app/proxyman/outbound"
 	. "v2ray.com/core/app/router"
 	"v2ray.com/core/common"
+	"v2ray.com/core/common/errors"
+	"v2ray.com/core/common/log"
 	"v2ray.com/core/common/net"
 	"v2ray.com/core/common/serial"
 	"v2ray.com/core/proxy"
@@ -46,3 +48,1234 @@ func TestSimpleRouter(t *testing.T) {
 	assert(err, IsNil)
 	assert(tag, Equals, "test")
 }
+
+type staticDNSClient struct {
+	ips     map[string][]net.IP
+	lookups int
+}
+
+func (*staticDNSClient) Start() error { return nil }
+func (*staticDNSClient) Close()       {}
+
+func (c *staticDNSClient) LookupIP(host string) ([]net.IP, error) {
+	c.lookups++
+	if ips, found := c.ips[host]; found {
+		return ips, nil
+	}
+	return nil, errors.New("NXDOMAIN: ", host)
+}
+
+func TestResolutionFailed(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DomainStrategy: Config_IpIfNonMatch,
+				Rule: []*RoutingRule{
+					{
+						Tag:              "blackhole",
+						ResolutionFailed: true,
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	dns := &staticDNSClient{
+		ips: map[string][]net.IP{
+			"v2ray.com": {net.IP{127, 0, 0, 1}},
+		},
+	}
+	common.Must(v.RegisterFeature((*core.DNSClient)(nil), dns))
+
+	r := v.Router()
+
+	ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("nx.v2ray.com"), 80))
+	tag, err := r.PickRoute(ctx)
+	assert(err, IsNil)
+	assert(tag, Equals, "blackhole")
+	assert(dns.lookups, Equals, 1)
+
+	// Negative result is cached.
+	tag, err = r.PickRoute(ctx)
+	assert(err, IsNil)
+	assert(tag, Equals, "blackhole")
+	assert(dns.lookups, Equals, 1)
+
+	ctx = proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80))
+	_, err = r.PickRoute(ctx)
+	assert(err, Equals, core.ErrNoClue)
+
+	ctx = proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("8.8.8.8"), 80))
+	_, err = r.PickRoute(ctx)
+	assert(err, Equals, core.ErrNoClue)
+}
+
+func TestRateLimitIpIfNonMatch(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DomainStrategy:     Config_IpIfNonMatch,
+				DefaultOutboundTag: "fallback",
+				Rule: []*RoutingRule{
+					{
+						Tag: "limited",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{127, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+						RateLimit: &RateLimit{
+							Rate:  1,
+							Burst: 2,
+						},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	dns := &staticDNSClient{
+		ips: map[string][]net.IP{
+			"v2ray.com": {net.IP{127, 0, 0, 1}},
+		},
+	}
+	common.Must(v.RegisterFeature((*core.DNSClient)(nil), dns))
+
+	r := v.Router()
+
+	// The rule is applied in both passes, but each connection only takes one token.
+	ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80))
+	for _, expected := range []string{"limited", "limited", "fallback"} {
+		tag, err := r.PickRoute(ctx)
+		assert(err, IsNil)
+		assert(tag, Equals, expected)
+	}
+}
+
+func TestRouterConfigErrors(t *testing.T) {
+	assert := With(t)
+
+	newRouter := func(rules ...*RoutingRule) error {
+		_, err := core.New(&core.Config{
+			App: []*serial.TypedMessage{
+				serial.ToTypedMessage(&Config{
+					Rule: rules,
+				}),
+			},
+		})
+		return err
+	}
+
+	validRule := &RoutingRule{
+		Tag:        "direct",
+		InboundTag: []string{"in"},
+	}
+
+	err := newRouter(validRule, &RoutingRule{
+		Tag: "test",
+		Cidr: []*CIDR{
+			{Ip: []byte{10, 0, 0}, Prefix: 8},
+		},
+	})
+	cidrErr, ok := err.(*InvalidCIDRError)
+	assert(ok, IsTrue)
+	assert(cidrErr.RuleIndex, Equals, 1)
+	assert(cidrErr.Reason, Equals, "invalid IP length")
+
+	err = newRouter(&RoutingRule{
+		Tag: "test",
+		SourceCidr: []*CIDR{
+			{Ip: []byte{10, 0, 0, 0}, Prefix: 33},
+		},
+	})
+	cidrErr, ok = err.(*InvalidCIDRError)
+	assert(ok, IsTrue)
+	assert(cidrErr.RuleIndex, Equals, 0)
+	assert(cidrErr.Prefix, Equals, uint32(33))
+
+	err = newRouter(validRule, validRule, &RoutingRule{
+		Tag: "test",
+		Domain: []*Domain{
+			{Type: Domain_Regex, Value: "v2ray.(com"},
+		},
+	})
+	regexErr, ok := err.(*BadRegexError)
+	assert(ok, IsTrue)
+	assert(regexErr.RuleIndex, Equals, 2)
+	assert(regexErr.Pattern, Equals, "v2ray.(com")
+	assert(regexErr.Err, IsNotNil)
+
+	err = newRouter(&RoutingRule{
+		Tag: "test",
+	})
+	emptyErr, ok := err.(*EmptyRuleError)
+	assert(ok, IsTrue)
+	assert(emptyErr.RuleIndex, Equals, 0)
+	assert(emptyErr.Error(), Equals, "rule 0 has no effective fields")
+}
+
+func TestDefaultOutboundTag(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DefaultOutboundTag: "fallback",
+				Rule: []*RoutingRule{
+					{
+						Tag:       "test",
+						PortRange: net.SinglePortRange(443),
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80))
+	tag, err := r.PickRoute(ctx)
+	assert(err, IsNil)
+	assert(tag, Equals, "fallback")
+
+	ctx = proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 443))
+	tag, err = r.PickRoute(ctx)
+	assert(err, IsNil)
+	assert(tag, Equals, "test")
+}
+
+func TestNumericIPDomain(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				Rule: []*RoutingRule{
+					{
+						Tag: "test",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{127, 0, 0, 1},
+								Prefix: 32,
+							},
+						},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	for _, domain := range []string{
+		"2130706433",
+		"0x7f000001",
+		"0X7F000001",
+		"017700000001",
+		"0x7f.0.0.1",
+		"0177.0.0.01",
+		"127.0.1",
+		"127.1",
+		"0x7f.1",
+		"0177.0x0.0.1",
+	} {
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(domain), 80))
+		tag, err := r.PickRoute(ctx)
+		assert(err, IsNil)
+		assert(tag, Equals, "test")
+	}
+
+	for _, domain := range []string{
+		"v2ray.com",
+		"127.0.0.2.com",
+		"0x7f000002",
+		"0x1ff.0.0.1",
+		"127.0.0.256",
+		"0x",
+		"08.0.0.1",
+		"1.2.3.4.5",
+		"",
+	} {
+		ctx := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(domain), 80))
+		_, err := r.PickRoute(ctx)
+		assert(err, Equals, core.ErrNoClue)
+	}
+}
+
+func TestRouterConfigLimits(t *testing.T) {
+	assert := With(t)
+
+	config := &Config{
+		Rule: []*RoutingRule{
+			{
+				Tag: "a",
+				Domain: []*Domain{
+					{Type: Domain_Domain, Value: "v2ray.com"},
+					{Type: Domain_Domain, Value: "v2ray.org"},
+				},
+				SourceCidr: []*CIDR{
+					{Ip: []byte{10, 0, 0, 0}, Prefix: 8},
+				},
+			},
+			{
+				Tag: "b",
+				Cidr: []*CIDR{
+					{Ip: []byte{8, 8, 8, 8}, Prefix: 32},
+					{Ip: []byte{8, 8, 4, 4}, Prefix: 32},
+				},
+			},
+		},
+	}
+	newRouter := func() error {
+		_, err := core.New(&core.Config{
+			App: []*serial.TypedMessage{
+				serial.ToTypedMessage(config),
+			},
+		})
+		return err
+	}
+
+	assert(newRouter(), IsNil)
+
+	config.MaxRules = 2
+	config.MaxDomains = 2
+	config.MaxCidrs = 3
+	assert(newRouter(), IsNil)
+
+	config.MaxCidrs = 2
+	err := newRouter()
+	limitErr, ok := err.(*LimitExceededError)
+	assert(ok, IsTrue)
+	assert(limitErr.Limit, Equals, "max_cidrs")
+	assert(limitErr.Actual, Equals, 3)
+	assert(err.Error(), Equals, "routing config exceeds max_cidrs: 3 > 2")
+
+	config.MaxDomains = 1
+	limitErr, ok = newRouter().(*LimitExceededError)
+	assert(ok, IsTrue)
+	assert(limitErr.Limit, Equals, "max_domains")
+
+	config.MaxRules = 1
+	limitErr, ok = newRouter().(*LimitExceededError)
+	assert(ok, IsTrue)
+	assert(limitErr.Limit, Equals, "max_rules")
+}
+
+func TestRouterConfigLimitsCIDRSets(t *testing.T) {
+	assert := With(t)
+
+	config := &Config{
+		CidrSets: map[string]*CIDRList{
+			"dns": {
+				Cidr: []*CIDR{
+					{Ip: []byte{8, 8, 8, 8}, Prefix: 32},
+					{Ip: []byte{8, 8, 4, 4}, Prefix: 32},
+				},
+			},
+		},
+		TrustedSource:      []*CIDR{{Ip: []byte{192, 168, 0, 0}, Prefix: 16}},
+		TrustedOutboundTag: "direct",
+		Rule: []*RoutingRule{
+			{
+				Tag:     "a",
+				CidrSet: []string{"dns"},
+			},
+			{
+				Tag:       "b",
+				CidrSet:   []string{"dns"},
+				PortRange: net.SinglePortRange(53),
+			},
+		},
+		MaxCidrs: 5,
+	}
+	newRouter := func() error {
+		_, err := core.New(&core.Config{
+			App: []*serial.TypedMessage{
+				serial.ToTypedMessage(config),
+			},
+		})
+		return err
+	}
+
+	assert(newRouter(), IsNil)
+
+	config.MaxCidrs = 4
+	limitErr, ok := newRouter().(*LimitExceededError)
+	assert(ok, IsTrue)
+	assert(limitErr.Limit, Equals, "max_cidrs")
+	assert(limitErr.Actual, Equals, 5)
+}
+
+func TestSourceConnections(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				Rule: []*RoutingRule{
+					{
+						Tag:                  "busy",
+						MinSourceConnections: 3,
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	connect := func(source string) (string, *proxy.Route) {
+		route := &proxy.Route{RuleIndex: -1}
+		ctx := proxy.ContextWithRoute(context.Background(), route)
+		ctx = proxy.ContextWithSource(ctx, net.TCPDestination(net.ParseAddress(source), 10000))
+		ctx = proxy.ContextWithTarget(ctx, net.TCPDestination(net.DomainAddress("v2ray.com"), 80))
+		tag, err := r.PickRoute(ctx)
+		if err != nil {
+			return "", route
+		}
+		return tag, route
+	}
+
+	tag, route1 := connect("10.0.0.1")
+	assert(tag, Equals, "")
+	tag, route2 := connect("10.0.0.1")
+	assert(tag, Equals, "")
+	tag, route3 := connect("10.0.0.1")
+	assert(tag, Equals, "busy")
+
+	// Other sources are counted separately.
+	tag, route4 := connect("10.0.0.2")
+	assert(tag, Equals, "")
+	route4.Close()
+
+	route3.Close()
+	route2.Close()
+
+	tag, route2 = connect("10.0.0.1")
+	assert(tag, Equals, "")
+	tag, route3 = connect("10.0.0.1")
+	assert(tag, Equals, "busy")
+
+	// Connections without a route are not counted after they are routed.
+	tag, _ = r.PickRoute(proxy.ContextWithTarget(proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress("10.0.0.2"), 10000)), net.TCPDestination(net.DomainAddress("v2ray.com"), 80)))
+	assert(tag, Equals, "")
+
+	route1.Close()
+	route2.Close()
+	route3.Close()
+}
+
+func TestPortProfiles(t *testing.T) {
+	assert := With(t)
+
+	web := &PortList{
+		Range: []*net.PortRange{
+			net.SinglePortRange(80),
+			net.SinglePortRange(443),
+			{From: 8080, To: 8090},
+		},
+	}
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				PortProfiles: map[string]*PortList{
+					"web": web,
+				},
+				Rule: []*RoutingRule{
+					{
+						Tag:         "blocked",
+						Domain:      []*Domain{{Type: Domain_Domain, Value: "example.com"}},
+						PortProfile: []string{"web"},
+					},
+					{
+						Tag:         "web",
+						PortRange:   net.SinglePortRange(22),
+						PortProfile: []string{"web"},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	testCases := []struct {
+		dest net.Destination
+		tag  string
+	}{
+		{net.TCPDestination(net.DomainAddress("example.com"), 443), "blocked"},
+		{net.TCPDestination(net.DomainAddress("v2ray.com"), 8085), "web"},
+		{net.TCPDestination(net.DomainAddress("v2ray.com"), 22), "web"},
+		{net.TCPDestination(net.DomainAddress("example.com"), 22), "web"},
+	}
+	for _, tc := range testCases {
+		tag, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), tc.dest))
+		assert(err, IsNil)
+		assert(tag, Equals, tc.tag)
+	}
+
+	_, err = r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 8091)))
+	assert(err, IsNotNil)
+
+	_, err = core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				PortProfiles: map[string]*PortList{
+					"web": web,
+				},
+				Rule: []*RoutingRule{
+					{
+						Tag:         "test",
+						PortProfile: []string{"ssh"},
+					},
+				},
+			}),
+		},
+	})
+	assert(err, IsNotNil)
+}
+
+func TestLintBroadRule(t *testing.T) {
+	assert := With(t)
+
+	config := &Config{
+		Rule: []*RoutingRule{
+			{
+				Tag:        "direct",
+				InboundTag: []string{"in"},
+				PortRange:  net.SinglePortRange(443),
+			},
+			{
+				Tag:        "proxy",
+				InboundTag: []string{"in"},
+			},
+			{
+				Tag:         "proxy",
+				InboundTag:  []string{"in2"},
+				Intentional: true,
+			},
+		},
+	}
+
+	errs := config.Lint()
+	assert(len(errs), Equals, 1)
+	broad, ok := errs[0].(*BroadRuleError)
+	assert(ok, IsTrue)
+	assert(broad.RuleIndex, Equals, 1)
+	assert(broad.Tag, Equals, "proxy")
+
+	config.Rule[1].Intentional = true
+	assert(len(config.Lint()), Equals, 0)
+}
+
+func TestReachableOutbounds(t *testing.T) {
+	assert := With(t)
+
+	config := &Config{
+		DefaultOutboundTag: "fallback",
+		Rule: []*RoutingRule{
+			{
+				Tag:        "proxy",
+				InboundTag: []string{"in"},
+			},
+			{
+				Tag:       "direct",
+				PortRange: net.SinglePortRange(53),
+			},
+			{
+				Tag:       "proxy",
+				PortRange: net.SinglePortRange(443),
+			},
+		},
+	}
+	assert(config.ReachableOutbounds(), Equals, []string{"direct", "fallback", "proxy"})
+
+	config.DnsOutboundTag = "dns-out"
+	assert(config.ReachableOutbounds(), Equals, []string{"direct", "dns-out", "fallback", "proxy"})
+
+	config.TrustedOutboundTag = "lan"
+	assert(config.ReachableOutbounds(), Equals, []string{"direct", "dns-out", "fallback", "lan", "proxy"})
+
+	assert(len((&Config{}).ReachableOutbounds()), Equals, 0)
+}
+
+func TestUnanchoredRegexConfig(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				UnanchoredRegex: true,
+				Rule: []*RoutingRule{
+					{
+						Tag:    "test",
+						Domain: []*Domain{{Type: Domain_Regex, Value: "google"}},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	tag, err := v.Router().PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("notgoogle.com"), 80)))
+	assert(err, IsNil)
+	assert(tag, Equals, "test")
+}
+
+func TestRouteRecord(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DefaultOutboundTag: "fallback",
+				Rule: []*RoutingRule{
+					{
+						Tag:       "dns",
+						PortRange: net.SinglePortRange(53),
+					},
+					{
+						Tag:       "web",
+						PortRange: net.SinglePortRange(443),
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	route := &proxy.Route{RuleIndex: -1}
+	ctx := proxy.ContextWithRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 443)), route)
+	tag, err := r.PickRoute(ctx)
+	assert(err, IsNil)
+	assert(tag, Equals, "web")
+	assert(route.RuleIndex, Equals, 1)
+	assert(route.OutboundTag, Equals, "web")
+
+	route = &proxy.Route{RuleIndex: -1}
+	ctx = proxy.ContextWithRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80)), route)
+	tag, err = r.PickRoute(ctx)
+	assert(err, IsNil)
+	assert(tag, Equals, "fallback")
+	assert(route.RuleIndex, Equals, -1)
+	assert(route.OutboundTag, Equals, "fallback")
+}
+
+func TestRouteFragmentClientHello(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DefaultOutboundTag: "fallback",
+				Rule: []*RoutingRule{
+					{
+						Tag:                 "fragment",
+						Domain:              []*Domain{{Type: Domain_Domain, Value: "v2ray.com"}},
+						FragmentClientHello: true,
+					},
+					{
+						Tag:       "web",
+						PortRange: net.SinglePortRange(443),
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+	pick := func(domain string) *proxy.Route {
+		route := &proxy.Route{RuleIndex: -1}
+		ctx := proxy.ContextWithRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress(domain), 443)), route)
+		_, err := r.PickRoute(ctx)
+		assert(err, IsNil)
+		return route
+	}
+
+	route := pick("www.v2ray.com")
+	assert(route.OutboundTag, Equals, "fragment")
+	assert(route.FragmentClientHello, IsTrue)
+
+	route = pick("example.com")
+	assert(route.OutboundTag, Equals, "web")
+	assert(route.FragmentClientHello, IsFalse)
+}
+
+func TestCheapConditionsFirst(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DomainStrategy: Config_IpOnDemand,
+				Rule: []*RoutingRule{
+					{
+						Tag: "test",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{127, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+						InboundTag: []string{"in"},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	dns := &staticDNSClient{
+		ips: map[string][]net.IP{
+			"v2ray.com": {net.IP{127, 0, 0, 1}},
+		},
+	}
+	common.Must(v.RegisterFeature((*core.DNSClient)(nil), dns))
+
+	r := v.Router()
+	target := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80))
+
+	// Inbound tag doesn't match, so the domain is not resolved for the CIDR.
+	_, err = r.PickRoute(proxy.ContextWithInboundTag(target, "other"))
+	assert(err, IsNotNil)
+	assert(dns.lookups, Equals, 0)
+
+	tag, err := r.PickRoute(proxy.ContextWithInboundTag(target, "in"))
+	assert(err, IsNil)
+	assert(tag, Equals, "test")
+	assert(dns.lookups, Equals, 1)
+}
+
+func TestRuleDomainStrategy(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DomainStrategy: Config_AsIs,
+				Rule: []*RoutingRule{
+					{
+						Tag: "asis",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{10, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+					},
+					{
+						Tag: "resolved",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{10, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+						InboundTag: []string{"in"},
+						DomainStrategy: &DomainStrategyOverride{
+							DomainStrategy: Config_UseIp,
+						},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	dns := &staticDNSClient{
+		ips: map[string][]net.IP{
+			"v2ray.com": {net.IP{10, 0, 0, 1}},
+		},
+	}
+	common.Must(v.RegisterFeature((*core.DNSClient)(nil), dns))
+
+	r := v.Router()
+	target := proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80))
+
+	// Only the overriding rule resolves the domain.
+	_, err = r.PickRoute(proxy.ContextWithInboundTag(target, "other"))
+	assert(err, IsNotNil)
+	assert(dns.lookups, Equals, 0)
+
+	tag, err := r.PickRoute(proxy.ContextWithInboundTag(target, "in"))
+	assert(err, IsNil)
+	assert(tag, Equals, "resolved")
+	assert(dns.lookups, Equals, 1)
+
+	tag, err = r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("10.1.1.1"), 80)))
+	assert(err, IsNil)
+	assert(tag, Equals, "asis")
+}
+
+func TestUseIpDomainStrategy(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DomainStrategy: Config_UseIp,
+				Rule: []*RoutingRule{
+					{
+						Tag: "global",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{10, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+					},
+					{
+						Tag: "override",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{10, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+						DomainStrategy: &DomainStrategyOverride{DomainStrategy: Config_UseIp},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	dns := &staticDNSClient{
+		ips: map[string][]net.IP{
+			"v2ray.com": {net.IP{10, 0, 0, 1}},
+		},
+	}
+	common.Must(v.RegisterFeature((*core.DNSClient)(nil), dns))
+
+	r := v.Router()
+	tag, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("v2ray.com"), 80)))
+	assert(err, IsNil)
+	// UseIp in Config doesn't resolve domains, but UseIp of a rule does.
+	assert(tag, Equals, "override")
+	assert(dns.lookups, Equals, 1)
+}
+
+func TestCIDRSets(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				CidrSets: map[string]*CIDRList{
+					"cdn": {
+						Cidr: []*CIDR{
+							{Ip: []byte{104, 16, 0, 0}, Prefix: 13},
+							{Ip: []byte{151, 101, 0, 0}, Prefix: 16},
+							{Ip: net.ParseIP("2606:4700::"), Prefix: 32},
+						},
+					},
+				},
+				Rule: []*RoutingRule{
+					{
+						Tag:     "direct",
+						CidrSet: []string{"cdn"},
+					},
+					{
+						Tag: "lan",
+						Cidr: []*CIDR{
+							{Ip: []byte{192, 168, 0, 0}, Prefix: 16},
+						},
+						CidrSet: []string{"cdn"},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	testCases := []struct {
+		ip  string
+		tag string
+	}{
+		{"104.16.132.229", "direct"},
+		{"151.101.1.69", "direct"},
+		{"2606:4700::6810:84e5", "direct"},
+		{"192.168.1.1", "lan"},
+	}
+	for _, tc := range testCases {
+		tag, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress(tc.ip), 443)))
+		assert(err, IsNil)
+		assert(tag, Equals, tc.tag)
+	}
+
+	_, err = r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("8.8.8.8"), 443)))
+	assert(err, IsNotNil)
+
+	_, err = core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				Rule: []*RoutingRule{
+					{
+						Tag:     "direct",
+						CidrSet: []string{"cdn"},
+					},
+				},
+			}),
+		},
+	})
+	assert(err, IsNotNil)
+}
+
+type decisionLogger struct {
+	decisions []*RouteDecision
+}
+
+func (l *decisionLogger) Handle(msg log.Message) {
+	if m, ok := msg.(*log.GeneralMessage); ok {
+		if d, ok := m.Content.(*RouteDecision); ok {
+			l.decisions = append(l.decisions, d)
+		}
+	}
+}
+
+func TestRouteDecisionLog(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DomainStrategy:     Config_IpIfNonMatch,
+				DefaultOutboundTag: "fallback",
+				Rule: []*RoutingRule{
+					{
+						Tag:       "dns",
+						PortRange: net.SinglePortRange(53),
+					},
+					{
+						Tag: "local",
+						Cidr: []*CIDR{
+							{
+								Ip:     []byte{127, 0, 0, 0},
+								Prefix: 8,
+							},
+						},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+	common.Must(v.RegisterFeature((*core.DNSClient)(nil), &staticDNSClient{
+		ips: map[string][]net.IP{
+			"v2ray.com": {net.IP{127, 0, 0, 1}},
+		},
+	}))
+
+	logger := new(decisionLogger)
+	log.RegisterHandler(logger)
+
+	r := v.Router()
+	dns := net.UDPDestination(net.ParseAddress("8.8.8.8"), 53)
+	web := net.TCPDestination(net.DomainAddress("v2ray.com"), 443)
+	other := net.TCPDestination(net.DomainAddress("nx.v2ray.com"), 443)
+	for _, dest := range []net.Destination{dns, web, other} {
+		_, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), dest))
+		assert(err, IsNil)
+	}
+
+	assert(len(logger.decisions), Equals, 3)
+
+	d := logger.decisions[0]
+	assert(d.Destination, Equals, dns)
+	assert(d.RuleIndex, Equals, 0)
+	assert(d.OutboundTag, Equals, "dns")
+	assert(len(d.ResolvedIPs), Equals, 0)
+
+	d = logger.decisions[1]
+	assert(d.Destination, Equals, web)
+	assert(d.RuleIndex, Equals, 1)
+	assert(d.OutboundTag, Equals, "local")
+	assert(len(d.ResolvedIPs), Equals, 1)
+	assert(d.ResolvedIPs[0].String(), Equals, "127.0.0.1")
+	assert(d.String(), HasSubstring, "rule=1 outbound=local")
+
+	d = logger.decisions[2]
+	assert(d.RuleIndex, Equals, -1)
+	assert(d.OutboundTag, Equals, "fallback")
+	assert(d.Err, IsNil)
+}
+
+func TestDNSOutboundTag(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DnsOutboundTag: "dns-out",
+				Rule: []*RoutingRule{
+					{
+						Tag:  "blocked",
+						Cidr: []*CIDR{{Ip: []byte{8, 8, 8, 8}, Prefix: 32}},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+
+	for _, dest := range []net.Destination{
+		net.UDPDestination(net.ParseAddress("8.8.8.8"), 53),
+		net.TCPDestination(net.ParseAddress("1.1.1.1"), 53),
+		net.UDPDestination(net.DomainAddress("dns.google"), 53),
+	} {
+		tag, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), dest))
+		assert(err, IsNil)
+		assert(tag, Equals, "dns-out")
+	}
+
+	tag, err := r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("8.8.8.8"), 443)))
+	assert(err, IsNil)
+	assert(tag, Equals, "blocked")
+
+	_, err = r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.ParseAddress("1.1.1.1"), 443)))
+	assert(err, IsNotNil)
+}
+
+func TestOutboundCapacity(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DefaultOutboundTag: "overflow",
+				Rule: []*RoutingRule{
+					{
+						Tag:                    "primary",
+						PortRange:              net.SinglePortRange(443),
+						MaxOutboundConnections: 2,
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+	pick := func() (string, *proxy.Route) {
+		route := &proxy.Route{RuleIndex: -1}
+		ctx := proxy.ContextWithRoute(context.Background(), route)
+		tag, err := r.PickRoute(proxy.ContextWithTarget(ctx, net.TCPDestination(net.DomainAddress("v2ray.com"), 443)))
+		assert(err, IsNil)
+		return tag, route
+	}
+
+	tag1, route1 := pick()
+	tag2, route2 := pick()
+	tag3, route3 := pick()
+	defer route2.Close()
+	defer route3.Close()
+	assert(tag1, Equals, "primary")
+	assert(tag2, Equals, "primary")
+	assert(tag3, Equals, "overflow")
+
+	route1.Close()
+	tag, route := pick()
+	defer route.Close()
+	assert(tag, Equals, "primary")
+}
+
+func TestTrustedSource(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				TrustedSource:      []*CIDR{{Ip: []byte{192, 168, 0, 0}, Prefix: 16}},
+				TrustedOutboundTag: "direct",
+				DnsOutboundTag:     "dns-out",
+				Rule: []*RoutingRule{
+					{
+						Tag:       "proxy",
+						PortRange: &net.PortRange{From: 1, To: 65535},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	r := v.Router()
+	pick := func(source string, port net.Port) string {
+		ctx := proxy.ContextWithSource(context.Background(), net.TCPDestination(net.ParseAddress(source), 10000))
+		ctx = proxy.ContextWithTarget(ctx, net.TCPDestination(net.ParseAddress("8.8.8.8"), port))
+		tag, err := r.PickRoute(ctx)
+		assert(err, IsNil)
+		return tag
+	}
+
+	assert(pick("192.168.1.2", 443), Equals, "direct")
+	assert(pick("192.168.1.2", 53), Equals, "direct")
+	assert(pick("10.0.0.2", 443), Equals, "proxy")
+	assert(pick("10.0.0.2", 53), Equals, "dns-out")
+}
+
+func TestTrustedSourceWithoutTag(t *testing.T) {
+	assert := With(t)
+
+	_, err := core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				TrustedSource: []*CIDR{{Ip: []byte{192, 168, 0, 0}, Prefix: 16}},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	})
+	assert(err, IsNotNil)
+}
+
+type warningLogger struct {
+	decisionLogger
+	warnings []string
+}
+
+func (l *warningLogger) Handle(msg log.Message) {
+	if m, ok := msg.(*log.GeneralMessage); ok && m.Severity == log.Severity_Warning {
+		l.warnings = append(l.warnings, m.String())
+	}
+	l.decisionLogger.Handle(msg)
+}
+
+func TestSniffingDisabled(t *testing.T) {
+	assert := With(t)
+
+	config := &core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&Config{
+				DefaultOutboundTag: "default",
+				Rule: []*RoutingRule{
+					{
+						Tag:    "domain",
+						Domain: []*Domain{{Type: Domain_Domain, Value: "v2ray.com"}},
+					},
+				},
+			}),
+			serial.ToTypedMessage(&dispatcher.Config{}),
+			serial.ToTypedMessage(&proxyman.OutboundConfig{}),
+		},
+	}
+
+	v, err := core.New(config)
+	common.Must(err)
+
+	logger := new(warningLogger)
+	log.RegisterHandler(logger)
+
+	r := v.Router()
+	ipDest := net.TCPDestination(net.ParseAddress("1.2.3.4"), 443)
+
+	sniffCtx := proxy.ContextWithSniffingEnabled(context.Background())
+	tag, err := r.PickRoute(proxy.ContextWithTarget(sniffCtx, ipDest))
+	assert(err, IsNil)
+	assert(tag, Equals, "default")
+	assert(len(logger.warnings), Equals, 0)
+
+	tag, err = r.PickRoute(proxy.ContextWithTarget(context.Background(), net.TCPDestination(net.DomainAddress("www.v2ray.com"), 443)))
+	assert(err, IsNil)
+	assert(tag, Equals, "domain")
+	assert(len(logger.warnings), Equals, 0)
+
+	for i := 0; i < 2; i++ {
+		tag, err = r.PickRoute(proxy.ContextWithTarget(context.Background(), ipDest))
+		assert(err, IsNil)
+		assert(tag, Equals, "default")
+	}
+	assert(len(logger.warnings), Equals, 1)
+	assert(logger.warnings[0], HasSubstring, "sniffing is disabled")
+
+	assert(len(logger.decisions), Equals, 4)
+	assert(logger.decisions[0].SniffingDisabled, IsFalse)
+	assert(logger.decisions[3].SniffingDisabled, IsTrue)
+}
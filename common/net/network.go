@@ -1,6 +1,7 @@
 package net
 
 import (
+	"sort"
 	"strings"
 )
 
@@ -56,6 +57,28 @@ func (l NetworkList) HasNetwork(network Network) bool {
 	return false
 }
 
+// MergeNetworks returns the given networks sorted, with duplicates removed. The result is
+// the same for any order of the input.
+func MergeNetworks(networks []Network) []Network {
+	if len(networks) == 0 {
+		return nil
+	}
+
+	sorted := make([]Network, len(networks))
+	copy(sorted, networks)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	merged := sorted[:1]
+	for _, n := range sorted[1:] {
+		if n != merged[len(merged)-1] {
+			merged = append(merged, n)
+		}
+	}
+	return merged
+}
+
 func (l NetworkList) Get(idx int) Network {
 	return l.Network[idx]
 }
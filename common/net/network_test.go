@@ -0,0 +1,25 @@
+package net_test
+
+import (
+	"testing"
+
+	. "v2ray.com/core/common/net"
+	. "v2ray.com/ext/assert"
+)
+
+func TestMergeNetworks(t *testing.T) {
+	assert := With(t)
+
+	for _, input := range [][]Network{
+		{Network_UDP, Network_TCP},
+		{Network_TCP, Network_UDP, Network_TCP},
+		{Network_UDP, Network_UDP, Network_TCP, Network_UDP},
+	} {
+		merged := MergeNetworks(input)
+		assert(len(merged), Equals, 2)
+		assert(merged[0], Equals, Network_TCP)
+		assert(merged[1], Equals, Network_UDP)
+	}
+
+	assert(len(MergeNetworks(nil)), Equals, 0)
+}
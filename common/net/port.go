@@ -1,6 +1,7 @@
 package net
 
 import (
+	"sort"
 	"strconv"
 
 	"v2ray.com/core/common/serial"
@@ -71,3 +72,34 @@ func SinglePortRange(p Port) *PortRange {
 		To:   uint32(p),
 	}
 }
+
+// MergePortRanges returns the given ranges sorted by starting port, with
+// duplicated, overlapping and adjacent ranges merged. The result is the same
+// for any order of the input.
+func MergePortRanges(ranges []PortRange) []PortRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sorted := make([]PortRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].From != sorted[j].From {
+			return sorted[i].From < sorted[j].From
+		}
+		return sorted[i].To < sorted[j].To
+	})
+
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.From <= last.To+1 {
+			if r.To > last.To {
+				last.To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -16,3 +16,45 @@ func TestPortRangeContains(t *testing.T) {
 	}
 	assert(portRange.Contains(Port(53)), IsTrue)
 }
+
+func TestMergePortRanges(t *testing.T) {
+	assert := With(t)
+
+	expected := []PortRange{
+		{From: 22, To: 22},
+		{From: 80, To: 81},
+		{From: 443, To: 443},
+		{From: 8000, To: 8090},
+	}
+
+	inputs := [][]PortRange{
+		{
+			{From: 80, To: 80},
+			{From: 443, To: 443},
+			{From: 22, To: 22},
+			{From: 8000, To: 8080},
+			{From: 81, To: 81},
+			{From: 8080, To: 8090},
+		},
+		{
+			{From: 8080, To: 8090},
+			{From: 443, To: 443},
+			{From: 81, To: 81},
+			{From: 443, To: 443},
+			{From: 8000, To: 8085},
+			{From: 80, To: 80},
+			{From: 22, To: 22},
+			{From: 22, To: 22},
+		},
+	}
+	for _, input := range inputs {
+		merged := MergePortRanges(input)
+		assert(len(merged), Equals, len(expected))
+		for i := range expected {
+			assert(merged[i].From, Equals, expected[i].From)
+			assert(merged[i].To, Equals, expected[i].To)
+		}
+	}
+
+	assert(len(MergePortRanges(nil)), Equals, 0)
+}
@@ -15,6 +15,11 @@ const (
 	inboundEntryPointKey
 	inboundTagKey
 	resolvedIPsKey
+	inboundUsernameKey
+	inboundTransportKey
+	sniffingEnabledKey
+	sniffingResultKey
+	routeKey
 )
 
 // ContextWithSource creates a new context with given source.
@@ -64,6 +69,103 @@ func InboundTagFromContext(ctx context.Context) (string, bool) {
 	return v, ok
 }
 
+// ContextWithInboundUsername creates a new context with the username the client authenticated to the inbound with.
+func ContextWithInboundUsername(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, inboundUsernameKey, username)
+}
+
+// InboundUsernameFromContext retrieves the authenticated inbound username from the given context.
+func InboundUsernameFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(inboundUsernameKey).(string)
+	return v, ok
+}
+
+// ContextWithInboundTransport creates a new context with the transport the connection arrived over,
+// such as "tcp", "mkcp" or "websocket".
+func ContextWithInboundTransport(ctx context.Context, transport string) context.Context {
+	return context.WithValue(ctx, inboundTransportKey, transport)
+}
+
+// InboundTransportFromContext retrieves the inbound transport from the given context.
+func InboundTransportFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(inboundTransportKey).(string)
+	return v, ok
+}
+
+// ContextWithSniffingEnabled creates a new context that marks the inbound as sniffing the connection.
+func ContextWithSniffingEnabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sniffingEnabledKey, true)
+}
+
+// SniffingEnabledFromContext returns true if the inbound sniffs the connection.
+func SniffingEnabledFromContext(ctx context.Context) bool {
+	v, ok := ctx.Value(sniffingEnabledKey).(bool)
+	return ok && v
+}
+
+// SniffResult is the outcome of a successful protocol sniffing.
+type SniffResult interface {
+	// Protocol returns the name of the sniffed protocol, such as "http" or "tls".
+	Protocol() string
+	// Domain returns the domain name found in the payload.
+	Domain() string
+}
+
+// ContextWithSniffingResult creates a new context with the given sniffing result.
+func ContextWithSniffingResult(ctx context.Context, r SniffResult) context.Context {
+	return context.WithValue(ctx, sniffingResultKey, r)
+}
+
+// SniffingResultFromContext returns the sniffing result in the context, or nil if the connection was not sniffed.
+func SniffingResultFromContext(ctx context.Context) SniffResult {
+	if r, ok := ctx.Value(sniffingResultKey).(SniffResult); ok {
+		return r
+	}
+	return nil
+}
+
+// Route records the routing decision made for a connection.
+type Route struct {
+	// RuleIndex is the index of the routing rule that matched, or -1 if no rule matched.
+	RuleIndex int
+
+	// OutboundTag is the tag of the outbound picked by the router. Empty if no outbound was picked.
+	OutboundTag string
+
+	// FragmentClientHello is set if the matched rule asks the outbound to fragment the TLS ClientHello.
+	FragmentClientHello bool
+
+	onClose []func()
+}
+
+// OnClose registers a function to be called when the route is closed.
+func (r *Route) OnClose(f func()) {
+	r.onClose = append(r.onClose, f)
+}
+
+// Close is called by the dispatcher once the outbound is done with the connection. For outbounds
+// with mux, that is when the connection is handed over to the mux session.
+func (r *Route) Close() {
+	for _, f := range r.onClose {
+		f()
+	}
+	r.onClose = nil
+}
+
+// ContextWithRoute creates a new context with the given route. The router fills in the route when
+// picking an outbound, so that outbounds and other features can see which rule matched.
+func ContextWithRoute(ctx context.Context, r *Route) context.Context {
+	return context.WithValue(ctx, routeKey, r)
+}
+
+// RouteFromContext returns the route in the context, or nil if there is none.
+func RouteFromContext(ctx context.Context) *Route {
+	if r, ok := ctx.Value(routeKey).(*Route); ok {
+		return r
+	}
+	return nil
+}
+
 type IPResolver interface {
 	Resolve() []net.Address
 }
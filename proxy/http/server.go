@@ -18,6 +18,7 @@ import (
 	"v2ray.com/core/common/net"
 	http_proto "v2ray.com/core/common/protocol/http"
 	"v2ray.com/core/common/signal"
+	"v2ray.com/core/proxy"
 	"v2ray.com/core/transport/internet"
 )
 
@@ -122,6 +123,7 @@ Start:
 			_, err := conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"proxy\"\r\n\r\n"))
 			return err
 		}
+		ctx = proxy.ContextWithInboundUsername(ctx, user)
 	}
 
 	newError("request to Method [", request.Method, "] Host [", request.Host, "] with URL [", request.URL, "]").WriteToLog()
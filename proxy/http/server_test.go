@@ -0,0 +1,76 @@
+package http_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"v2ray.com/core"
+	"v2ray.com/core/app/proxyman"
+	_ "v2ray.com/core/app/proxyman/inbound"
+	"v2ray.com/core/common"
+	"v2ray.com/core/common/net"
+	"v2ray.com/core/common/serial"
+	"v2ray.com/core/proxy"
+	. "v2ray.com/core/proxy/http"
+	"v2ray.com/core/testing/servers/udp"
+	"v2ray.com/core/transport/ray"
+	. "v2ray.com/ext/assert"
+)
+
+// contextDispatcher passes the context of each dispatched connection to the channel.
+type contextDispatcher chan context.Context
+
+func (contextDispatcher) Start() error { return nil }
+func (contextDispatcher) Close()       {}
+
+func (d contextDispatcher) Dispatch(ctx context.Context, dest net.Destination) (ray.InboundRay, error) {
+	d <- ctx
+	return ray.NewRay(ctx), nil
+}
+
+func TestServerInboundUsername(t *testing.T) {
+	assert := With(t)
+
+	port := udp.PickPort()
+	v, err := core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(port),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+				ProxySettings: serial.ToTypedMessage(&ServerConfig{
+					Accounts: map[string]string{
+						"alice": "secret",
+					},
+				}),
+			},
+		},
+	})
+	common.Must(err)
+	dispatcher := make(contextDispatcher, 1)
+	common.Must(v.RegisterFeature((*core.Dispatcher)(nil), dispatcher))
+	common.Must(v.Start())
+	defer v.Close()
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: []byte{127, 0, 0, 1}, Port: int(port)})
+	common.Must(err)
+	defer conn.Close()
+
+	// YWxpY2U6c2VjcmV0 is alice:secret in base64.
+	_, err = conn.Write([]byte("GET http://127.0.0.1/ HTTP/1.1\r\nHost: 127.0.0.1\r\nProxy-Authorization: Basic YWxpY2U6c2VjcmV0\r\n\r\n"))
+	common.Must(err)
+
+	select {
+	case ctx := <-dispatcher:
+		username, found := proxy.InboundUsernameFromContext(ctx)
+		assert(found, IsTrue)
+		assert(username, Equals, "alice")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection dispatched")
+	}
+}
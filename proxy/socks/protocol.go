@@ -35,8 +35,9 @@ const (
 )
 
 type ServerSession struct {
-	config *ServerConfig
-	port   net.Port
+	config   *ServerConfig
+	port     net.Port
+	username string
 }
 
 func (s *ServerSession) Handshake(reader io.Reader, writer io.Writer) (*protocol.RequestHeader, error) {
@@ -116,6 +117,7 @@ func (s *ServerSession) Handshake(reader io.Reader, writer io.Writer) (*protocol
 			if err := writeSocks5AuthenticationResponse(writer, 0x01, 0x00); err != nil {
 				return nil, newError("failed to write auth response").Base(err)
 			}
+			s.username = username
 		}
 		if err := buffer.Reset(buf.ReadFullFrom(reader, 4)); err != nil {
 			return nil, newError("failed to read request").Base(err)
@@ -3,6 +3,7 @@ package socks
 import (
 	"context"
 	"io"
+	"sync"
 	"time"
 
 	"v2ray.com/core"
@@ -21,13 +22,24 @@ import (
 type Server struct {
 	config *ServerConfig
 	v      *core.Instance
+
+	// udpUsers maps the IPs of clients with a UDP association to the usernames they authenticated
+	// with, as UDP packets don't carry credentials.
+	access   sync.Mutex
+	udpUsers map[string]*udpUser
+}
+
+type udpUser struct {
+	username     string
+	associations int
 }
 
 // NewServer creates a new Server object.
 func NewServer(ctx context.Context, config *ServerConfig) (*Server, error) {
 	s := &Server{
-		config: config,
-		v:      core.FromContext(ctx),
+		config:   config,
+		v:        core.FromContext(ctx),
+		udpUsers: make(map[string]*udpUser),
 	}
 	if s.v == nil {
 		return nil, newError("V is not in context.")
@@ -92,6 +104,10 @@ func (s *Server) processTCP(ctx context.Context, conn internet.Connection, dispa
 	}
 	conn.SetReadDeadline(time.Time{})
 
+	if len(session.username) > 0 {
+		ctx = proxy.ContextWithInboundUsername(ctx, session.username)
+	}
+
 	if request.Command == protocol.RequestCommandTCP {
 		dest := request.Destination()
 		newError("TCP Connect request to ", dest).WriteToLog()
@@ -108,12 +124,53 @@ func (s *Server) processTCP(ctx context.Context, conn internet.Connection, dispa
 	}
 
 	if request.Command == protocol.RequestCommandUDP {
+		if source, ok := proxy.SourceFromContext(ctx); ok && len(session.username) > 0 {
+			ip := source.Address.String()
+			s.addUDPUser(ip, session.username)
+			defer s.removeUDPUser(ip)
+		}
 		return s.handleUDP(conn)
 	}
 
 	return nil
 }
 
+func (s *Server) addUDPUser(ip string, username string) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	u, found := s.udpUsers[ip]
+	if !found {
+		u = new(udpUser)
+		s.udpUsers[ip] = u
+	}
+	u.username = username
+	u.associations++
+}
+
+func (s *Server) removeUDPUser(ip string) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if u, found := s.udpUsers[ip]; found {
+		u.associations--
+		if u.associations == 0 {
+			delete(s.udpUsers, ip)
+		}
+	}
+}
+
+// udpUsername returns the username of the UDP association of the client IP, if any.
+func (s *Server) udpUsername(ip string) (string, bool) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	if u, found := s.udpUsers[ip]; found {
+		return u.username, true
+	}
+	return "", false
+}
+
 func (*Server) handleUDP(c net.Conn) error {
 	// The TCP connection closes after this method returns. We need to wait until
 	// the client closes it.
@@ -167,6 +224,9 @@ func (v *Server) handleUDPPayload(ctx context.Context, conn internet.Connection,
 
 	if source, ok := proxy.SourceFromContext(ctx); ok {
 		newError("client UDP connection from ", source).WriteToLog()
+		if username, found := v.udpUsername(source.Address.String()); found {
+			ctx = proxy.ContextWithInboundUsername(ctx, username)
+		}
 	}
 
 	reader := buf.NewReader(conn)
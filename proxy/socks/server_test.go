@@ -0,0 +1,104 @@
+package socks_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"v2ray.com/core"
+	"v2ray.com/core/app/proxyman"
+	_ "v2ray.com/core/app/proxyman/inbound"
+	"v2ray.com/core/common"
+	"v2ray.com/core/common/net"
+	"v2ray.com/core/common/serial"
+	"v2ray.com/core/proxy"
+	. "v2ray.com/core/proxy/socks"
+	"v2ray.com/core/testing/servers/udp"
+	"v2ray.com/core/transport/ray"
+	. "v2ray.com/ext/assert"
+)
+
+// contextDispatcher passes the context of each dispatched connection to the channel.
+type contextDispatcher chan context.Context
+
+func (contextDispatcher) Start() error { return nil }
+func (contextDispatcher) Close()       {}
+
+func (d contextDispatcher) Dispatch(ctx context.Context, dest net.Destination) (ray.InboundRay, error) {
+	d <- ctx
+	return ray.NewRay(ctx), nil
+}
+
+func (d contextDispatcher) username(t *testing.T) string {
+	select {
+	case ctx := <-d:
+		username, _ := proxy.InboundUsernameFromContext(ctx)
+		return username
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection dispatched")
+		return ""
+	}
+}
+
+// handshake authenticates as alice, and sends a request with the given command to 127.0.0.1:80.
+func handshake(conn net.Conn, command byte) error {
+	request := []byte{0x05, 0x01, 0x02, 0x01, 0x05}
+	request = append(request, "alice"...)
+	request = append(request, 0x06)
+	request = append(request, "secret"...)
+	request = append(request, 0x05, command, 0x00, 0x01, 127, 0, 0, 1, 0, 80)
+	_, err := conn.Write(request)
+	return err
+}
+
+func TestServerInboundUsername(t *testing.T) {
+	assert := With(t)
+
+	port := udp.PickPort()
+	v, err := core.New(&core.Config{
+		App: []*serial.TypedMessage{
+			serial.ToTypedMessage(&proxyman.InboundConfig{}),
+		},
+		Inbound: []*core.InboundHandlerConfig{
+			{
+				ReceiverSettings: serial.ToTypedMessage(&proxyman.ReceiverConfig{
+					PortRange: net.SinglePortRange(port),
+					Listen:    net.NewIPOrDomain(net.LocalHostIP),
+				}),
+				ProxySettings: serial.ToTypedMessage(&ServerConfig{
+					AuthType: AuthType_PASSWORD,
+					Accounts: map[string]string{
+						"alice": "secret",
+					},
+					Address:    net.NewIPOrDomain(net.LocalHostIP),
+					UdpEnabled: true,
+				}),
+			},
+		},
+	})
+	common.Must(err)
+	dispatcher := make(contextDispatcher, 1)
+	common.Must(v.RegisterFeature((*core.Dispatcher)(nil), dispatcher))
+	common.Must(v.Start())
+	defer v.Close()
+
+	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: []byte{127, 0, 0, 1}, Port: int(port)})
+	common.Must(err)
+	defer conn.Close()
+	common.Must(handshake(conn, 0x01))
+	assert(dispatcher.username(t), Equals, "alice")
+
+	// UDP packets are from the client of the association.
+	association, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: []byte{127, 0, 0, 1}, Port: int(port)})
+	common.Must(err)
+	defer association.Close()
+	common.Must(handshake(association, 0x03))
+	time.Sleep(100 * time.Millisecond)
+
+	packet, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: int(port)})
+	common.Must(err)
+	defer packet.Close()
+	_, err = packet.Write([]byte{0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 53, 'a'})
+	common.Must(err)
+	assert(dispatcher.username(t), Equals, "alice")
+}
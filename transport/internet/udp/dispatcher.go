@@ -43,7 +43,7 @@ func (v *Dispatcher) RemoveRay(dest net.Destination) {
 	}
 }
 
-func (v *Dispatcher) getInboundRay(dest net.Destination, callback ResponseCallback) *connEntry {
+func (v *Dispatcher) getInboundRay(ctx context.Context, dest net.Destination, callback ResponseCallback) *connEntry {
 	v.Lock()
 	defer v.Unlock()
 
@@ -53,7 +53,8 @@ func (v *Dispatcher) getInboundRay(dest net.Destination, callback ResponseCallba
 
 	newError("establishing new connection for ", dest).WriteToLog()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	// The connection inherits the inbound context, such as the source and the user.
+	ctx, cancel := context.WithCancel(ctx)
 	removeRay := func() {
 		cancel()
 		v.RemoveRay(dest)
@@ -74,7 +75,7 @@ func (v *Dispatcher) Dispatch(ctx context.Context, destination net.Destination,
 	// TODO: Add user to destString
 	newError("dispatch request to: ", destination).AtDebug().WriteToLog()
 
-	conn := v.getInboundRay(destination, callback)
+	conn := v.getInboundRay(ctx, destination, callback)
 	outputStream := conn.inbound.InboundInput()
 	if outputStream != nil {
 		if err := outputStream.WriteMultiBuffer(buf.NewMultiBufferValue(payload)); err != nil {